@@ -20,9 +20,14 @@ type Reader struct {
 	meta      []MetaField
 	metaRead  bool
 	peekLine  string
+	peekPos   int64
 	line      int
+	pos       int64 // byte offset of the next unread line
+	linePos   int64 // byte offset of the last line read
+	linkPos   int64 // byte offset of the last link read
 	format    Format
 	sourceLen int
+	strict    bool // continuation lines that look like links are links
 }
 
 type MetaField struct {
@@ -58,6 +63,15 @@ func NewURLTeamReader(r io.Reader, shortcodeLen int) *Reader {
 	return &Reader{r: bufio.NewReader(r), format: URLTeam, sourceLen: shortcodeLen}
 }
 
+// SetStrictSources controls whether, in a URLTeam link dump with a
+// fixed shortcode length, a line that looks like a link, with a source
+// of the wrong length before '|' and a URL after it, is read as a link
+// and returns *SourceLenError. Otherwise, it is ambiguous with a line
+// of a multi-line target and is appended to the previous target.
+func (r *Reader) SetStrictSources(strict bool) {
+	r.strict = strict
+}
+
 // Meta returns the meta fields in the header.
 func (r *Reader) Meta() ([]MetaField, error) {
 	if r.metaRead {
@@ -90,7 +104,7 @@ func (r *Reader) readMeta() ([]MetaField, error) {
 			break
 		}
 		if line[0] != '#' {
-			r.peekLine = line
+			r.peek(line)
 			return r.meta, nil
 		}
 		meta, err := splitMeta(line[1:])
@@ -107,7 +121,7 @@ func (r *Reader) readMeta() ([]MetaField, error) {
 			return r.meta, err
 		}
 		if trimLeftSpace(line) != "" {
-			r.peekLine = line
+			r.peek(line)
 			return r.meta, nil
 		}
 	}
@@ -115,11 +129,12 @@ func (r *Reader) readMeta() ([]MetaField, error) {
 
 // consumeBOM skips a UTF-8 byte order mark as permitted by section 3.1.
 func (r *Reader) consumeBOM() error {
-	ch, _, err := r.r.ReadRune()
+	ch, size, err := r.r.ReadRune()
 	if err != nil {
 		return err
 	}
 	if ch == '\uFEFF' {
+		r.pos += int64(size)
 		return nil
 	}
 	return r.r.UnreadRune()
@@ -152,11 +167,17 @@ func (r *Reader) Read() (link *Link, err error) {
 	return link, r.err(err)
 }
 
+// Offset returns the byte offset of the start of the last link read.
+func (r *Reader) Offset() int64 {
+	return r.linkPos
+}
+
 func (r *Reader) readLinkRFC() (*Link, error) {
 	line, err := r.readLine()
 	if err != nil {
 		return nil, err
 	}
+	r.linkPos = r.linePos
 	var link Link
 	tokens := strings.SplitN(line, "|", 4)
 	switch len(tokens) {
@@ -179,6 +200,7 @@ func (r *Reader) readLinkURLTeam() (*Link, error) {
 	if err != nil {
 		return nil, err
 	}
+	r.linkPos = r.linePos
 
 	// Variable shortcode length
 	if r.sourceLen <= 0 {
@@ -192,7 +214,7 @@ func (r *Reader) readLinkURLTeam() (*Link, error) {
 	// Fixed shortcode length
 	if len(line) < r.sourceLen || line[r.sourceLen] != '|' {
 		if i := strings.IndexByte(line, '|'); i != -1 {
-			return nil, fmt.Errorf("shortcode not %d characters: %q", r.sourceLen, line)
+			return nil, &SourceLenError{Len: r.sourceLen, Line: line}
 		}
 		return nil, fmt.Errorf("link line missing bar separator: %q", line)
 	}
@@ -206,8 +228,8 @@ func (r *Reader) readLinkURLTeam() (*Link, error) {
 			}
 			return nil, err
 		}
-		if len(line) > r.sourceLen && line[r.sourceLen] == '|' {
-			r.peekLine = line
+		if len(line) > r.sourceLen && line[r.sourceLen] == '|' || r.strict && looksLikeLink(line) {
+			r.peek(line)
 			break
 		}
 		target += line
@@ -215,6 +237,30 @@ func (r *Reader) readLinkURLTeam() (*Link, error) {
 	return &Link{shortcode, dropLineBreak(target), ""}, nil
 }
 
+// looksLikeLink reports whether a line is a shortcode, without URL
+// punctuation, followed by '|' and an absolute URL, as in
+// "abc|http://example.com/".
+func looksLikeLink(line string) bool {
+	i := strings.IndexByte(line, '|')
+	if i <= 0 || strings.ContainsAny(line[:i], " \t/:?#&=.") {
+		return false
+	}
+	target := line[i+1:]
+	j := strings.Index(target, "://")
+	if j <= 0 {
+		return false
+	}
+	for k, ch := range target[:j] {
+		switch {
+		case 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z':
+		case k > 0 && ('0' <= ch && ch <= '9' || ch == '+' || ch == '-' || ch == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (r *Reader) readLine() (string, error) {
 	line, err := r.readLineRaw()
 	if err != nil {
@@ -226,16 +272,37 @@ func (r *Reader) readLine() (string, error) {
 func (r *Reader) readLineRaw() (string, error) {
 	if l := r.peekLine; l != "" {
 		r.peekLine = ""
+		r.linePos = r.peekPos
 		return l, nil
 	}
 	r.line++
+	r.linePos = r.pos
 	line, err := r.r.ReadString('\n')
+	r.pos += int64(len(line))
 	if err != nil && !(err == io.EOF && line != "") {
 		return "", err
 	}
 	return line, nil
 }
 
+// peek saves the last line read to be returned by the next read.
+func (r *Reader) peek(line string) {
+	r.peekLine = line
+	r.peekPos = r.linePos
+}
+
+// SourceLenError is the error for a link whose source is not of the
+// fixed shortcode length of a URLTeam reader. The line has been
+// consumed, so reading can continue with the next link.
+type SourceLenError struct {
+	Len  int    // shortcode length
+	Line string // line of the link
+}
+
+func (e *SourceLenError) Error() string {
+	return fmt.Sprintf("shortcode not %d characters: %q", e.Len, e.Line)
+}
+
 func (r *Reader) err(err error) error {
 	if err == io.EOF || err == nil {
 		return err
@@ -6,7 +6,13 @@
 
 package beacon
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestSplitMeta(t *testing.T) {
 	tests := []struct {
@@ -35,3 +41,64 @@ func TestSplitMeta(t *testing.T) {
 		}
 	}
 }
+
+func TestOffset(t *testing.T) {
+	dump := "abc|http://example.com/1\n" +
+		"abd|http://example.com/\n2\n" +
+		"abe|http://example.com/3\r\n" +
+		"abf|http://example.com/4"
+	want := []struct {
+		source string
+		offset int64
+	}{{"abc", 0}, {"abd", 25}, {"abe", 51}, {"abf", 77}}
+	r := NewURLTeamReader(strings.NewReader(dump), 3)
+	for i, w := range want {
+		link, err := r.Read()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if link.Source != w.source || r.Offset() != w.offset {
+			t.Errorf("#%d: got %s at offset %d, want %s at offset %d", i, link.Source, r.Offset(), w.source, w.offset)
+		}
+		if got := dump[r.Offset():]; !strings.HasPrefix(got, w.source+"|") {
+			t.Errorf("#%d: offset %d does not start link: %q", i, r.Offset(), got)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
+
+func TestStrictSources(t *testing.T) {
+	dump := "abc|http://example.com/\n1\n" +
+		"abcd|http://example.com/2\n" +
+		"abe|http://example.com/a|b\n" +
+		"c|d\n"
+	for _, strict := range []bool{false, true} {
+		r := NewURLTeamReader(strings.NewReader(dump), 3)
+		r.SetStrictSources(strict)
+		var got []string
+		for {
+			link, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			var lenErr *SourceLenError
+			if errors.As(err, &lenErr) {
+				got = append(got, "wrong length")
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, link.String())
+		}
+		want := []string{"abc|http://example.com/\n1\nabcd|http://example.com/2", "abe|http://example.com/a|b\nc|d"}
+		if strict {
+			want = []string{"abc|http://example.com/\n1", "wrong length", "abe|http://example.com/a|b\nc|d"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("strict=%t: got %q, want %q", strict, got, want)
+		}
+	}
+}
@@ -7,25 +7,98 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
 	"github.com/andrewarchi/urlhero/tinytown"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s dir\n", os.Args[0])
+	workers := flag.Int("workers", 4, "number of torrents to download at once")
+	projects := flag.String("projects", "", "comma-separated project name patterns of zips to download, e.g., bitly_*")
+	noPeers := flag.Bool("nopeers", false, "download only from web seeds")
+	peerFallback := flag.Duration("peerfallback", 2*time.Minute, "duration without progress from web seeds before also connecting to peers")
+	verify := flag.Bool("verify", false, "rehash existing data before downloading")
+	indexDir := flag.String("index", "", "index directory to add project zips to as they complete")
+	linksFile := flag.String("links", "", "file to append the links of project zips to as they complete, as SOURCE|TARGET lines")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] dir\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(2)
 	}
-	dir := os.Args[1]
+	dir := flag.Arg(0)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "No such directory: %s", dir)
 		os.Exit(1)
 	}
 
-	if err := tinytown.DownloadTorrents(dir); err != nil {
+	options := &tinytown.TorrentOptions{
+		Workers:      *workers,
+		NoPeers:      *noPeers,
+		PeerFallback: *peerFallback,
+		Verify:       *verify,
+		Observer:     progress.NewWriter(os.Stdout),
+	}
+	var projectList []string
+	if *projects != "" {
+		projectList = strings.Split(*projects, ",")
+		options.Files = tinytown.ProjectFiles(projectList, nil)
+	}
+
+	if *indexDir != "" {
+		idx, err := tinytown.OpenIndex(*indexDir, dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.FileDone = func(release, filename string) error {
+			if !strings.HasSuffix(filename, ".zip") {
+				return nil
+			}
+			_, err := idx.Add(filename)
+			return err
+		}
+	}
+
+	var w *bufio.Writer
+	if *linksFile != "" {
+		f, err := os.OpenFile(*linksFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = bufio.NewWriter(f)
+		// Links are flushed after each dump, so that a zip recorded as
+		// processed has all of its links written.
+		options.ProcessOptions = &tinytown.ProcessOptions{
+			Projects: projectList,
+			DumpDone: func(d *tinytown.DumpSummary) { w.Flush() },
+		}
+		options.Process = func(l *beacon.Link, m *tinytown.Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+			w.WriteString(l.Source)
+			w.WriteByte('|')
+			w.WriteString(l.Target)
+			return w.WriteByte('\n')
+		}
+	}
+
+	err := tinytown.DownloadTorrents(dir, options)
+	if w != nil {
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
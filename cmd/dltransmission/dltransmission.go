@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	trpc "github.com/hekmon/transmissionrpc"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	host := flag.String("host", "localhost", "Transmission RPC host")
+	user := flag.String("user", "", "Transmission RPC user")
+	password := flag.String("password", "", "Transmission RPC password")
+	projects := flag.String("projects", "", "comma-separated project name patterns to download, e.g., bitly_*")
+	skip := flag.String("skip", "", "comma-separated project name patterns to skip")
+	poll := flag.Duration("poll", 30*time.Second, "interval between progress polls")
+	stall := flag.Duration("stall", 30*time.Minute, "duration without progress before a torrent is reported stalled")
+	timeout := flag.Duration("timeout", 0, "duration after which to stop monitoring; none when 0")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(0)
+
+	c, err := trpc.New(*host, *user, *password, nil)
+	try(err)
+	options := &tinytown.TransmissionOptions{
+		Projects:     splitList(*projects),
+		SkipProjects: splitList(*skip),
+		PollInterval: *poll,
+		StallTimeout: *stall,
+		Observer:     progress.NewWriter(os.Stdout),
+		Progress: func(p *tinytown.TransmissionProgress) {
+			percent := 100.0
+			if p.SizeWhenDone != 0 {
+				percent = 100 * float64(p.SizeWhenDone-p.LeftUntilDone) / float64(p.SizeWhenDone)
+			}
+			fmt.Printf("%d/%d torrents done, %.2f%%, %d bytes left, %d B/s\n",
+				p.Done, p.Torrents, percent, p.LeftUntilDone, p.RateDownload)
+			for _, name := range p.Stalled {
+				fmt.Printf("\tstalled: %s\n", name)
+			}
+			for _, name := range p.Paused {
+				fmt.Printf("\tpaused: %s\n", name)
+			}
+			for _, err := range p.Errors {
+				fmt.Printf("\terror: %s\n", err)
+			}
+		},
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	try(tinytown.DownloadTransmission(ctx, c, dir, options))
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
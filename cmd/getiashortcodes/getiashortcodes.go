@@ -7,23 +7,32 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
 
 	"github.com/andrewarchi/urlhero/shorteners"
+	"github.com/andrewarchi/urlhero/tinytown"
 )
 
 func main() {
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		fmt.Fprintln(os.Stderr, "Usage: getiashortcodes <shortener> [alphabet]")
+	releases := flag.String("releases", "", "terroroftinytown release directory to register URLTeam projects from")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: getiashortcodes [options] <shortener> [alphabet]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
 		os.Exit(2)
 	}
-	shortener := os.Args[1]
-	var alpha string
-	if len(os.Args) >= 3 {
-		alpha = os.Args[2]
+	shortener := flag.Arg(0)
+	alpha := flag.Arg(1)
+	if *releases != "" {
+		_, err := tinytown.RegisterProjects(*releases, nil)
+		try(err)
 	}
 
 	s, ok := shorteners.Lookup[shortener]
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/shorteners"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	d := tinytown.DefaultProjectDefaults
+	redirect := flag.String("redirect", joinInts(d.RedirectCodes), "comma-separated HTTP codes for found shortcodes")
+	noRedirect := flag.String("noredirect", joinInts(d.NoRedirectCodes), "comma-separated HTTP codes for unused shortcodes")
+	unavailable := flag.String("unavailable", joinInts(d.UnavailableCodes), "comma-separated HTTP codes for disabled shortcodes")
+	banned := flag.String("banned", joinInts(d.BannedCodes), "comma-separated HTTP codes when rate limited")
+	flag.StringVar(&d.Method, "method", d.Method, "HTTP method, head or get")
+	flag.Float64Var(&d.RequestDelay, "delay", d.RequestDelay, "seconds between requests")
+	flag.StringVar(&d.BodyRegex, "body-regex", "", "regular expression to extract the URL from the body")
+	flag.StringVar(&d.LocationAntiRegex, "location-anti-regex", "", "regular expression for locations that are not found URLs")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] SHORTENER\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	s, ok := shorteners.Lookup[flag.Arg(0)]
+	if !ok {
+		try(fmt.Errorf("unknown shortener: %s", flag.Arg(0)))
+	}
+
+	var err error
+	d.RedirectCodes, err = splitInts(*redirect)
+	try(err)
+	d.NoRedirectCodes, err = splitInts(*noRedirect)
+	try(err)
+	d.UnavailableCodes, err = splitInts(*unavailable)
+	try(err)
+	d.BannedCodes, err = splitInts(*banned)
+	try(err)
+
+	b, err := tinytown.MarshalProjectConfig(s, &d)
+	try(err)
+	os.Stdout.Write(b)
+}
+
+func splitInts(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var ints []int
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -7,19 +7,42 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/andrewarchi/urlhero/tinytown"
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Fprintf(os.Stderr, "Usage: %s dir shortener shortcodes...\n", os.Args[0])
+	indexDir := flag.String("index", "", "index directory built by tinyindex, to avoid scanning releases")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR PROJECT SHORTCODES...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 3 {
+		flag.Usage()
 		os.Exit(2)
 	}
-	dir, shortener, shortcodes := os.Args[1], os.Args[2], os.Args[3:]
-	if _, err := tinytown.SearchReleases(dir, shortener, shortcodes); err != nil {
+	dir, project, shortcodes := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+
+	var results []tinytown.SearchResult
+	var err error
+	if *indexDir != "" {
+		var idx *tinytown.Index
+		idx, err = tinytown.OpenIndex(*indexDir, dir)
+		if err == nil {
+			results, err = idx.Search(project, shortcodes)
+		}
+	} else {
+		results, err = tinytown.SearchReleases(dir, project, shortcodes)
+	}
+	for _, r := range results {
+		fmt.Printf("%s|%q\t%s\n", r.Link.Source, r.Link.Target, filepath.Base(r.ReleaseFilename))
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %s dir state [command [args...]]\n", os.Args[0])
+		os.Exit(2)
+	}
+	dir, state, command := os.Args[1], os.Args[2], os.Args[3:]
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "No such directory: %s\n", dir)
+		os.Exit(1)
+	}
+
+	// Run the command on each new project zip, with the zip filename
+	// appended to the arguments.
+	var processZip func(filename string) error
+	if len(command) != 0 {
+		processZip = func(filename string) error {
+			args := append(command[1:len(command):len(command)], filename)
+			cmd := exec.Command(command[0], args...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		}
+	}
+
+	ids, err := tinytown.SyncReleases(dir, state, progress.NewWriter(os.Stdout), processZip)
+	fmt.Printf("Synced %d releases\n", len(ids))
+	try(err)
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to process, e.g., bitly_*")
+	duplicates := flag.Bool("duplicates", false, "also report shortcodes with the same target in every release")
+	out := flag.String("o", "", "file to write conflicts CSV to; defaults to stdout")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Reports shortcodes found in more than one release, with a row per release.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := &tinytown.ProcessOptions{Workers: *workers, Observer: progress.NewWriter(os.Stderr)}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+	conflicts, err := tinytown.FindConflicts(flag.Arg(0), options)
+	try(err)
+
+	counts := make(map[tinytown.ConflictKind]int)
+	reported := conflicts[:0]
+	for _, c := range conflicts {
+		counts[c.Kind]++
+		if c.Kind != tinytown.Duplicate || *duplicates {
+			reported = append(reported, c)
+		}
+	}
+	if *out == "" {
+		try(tinytown.WriteConflictsCSV(os.Stdout, reported))
+	} else {
+		f, err := os.Create(*out)
+		try(err)
+		try(tinytown.WriteConflictsCSV(f, reported))
+		try(f.Close())
+	}
+	fmt.Fprintf(os.Stderr, "%d duplicate, %d truncated, %d changed\n",
+		counts[tinytown.Duplicate], counts[tinytown.TruncatedTarget], counts[tinytown.ChangedTarget])
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to report, e.g., bitly_*")
+	minGap := flag.Int64("gap", 1000, "minimum run of sequence numbers without links to report as a gap")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := &tinytown.ProcessOptions{Workers: *workers, Observer: progress.NewWriter(os.Stderr)}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+	coverage, err := tinytown.ScanCoverage(flag.Arg(0), options, *minGap)
+	try(err)
+	for _, c := range coverage {
+		fmt.Printf("%s (lower sequence number %d)\n", c.Project, c.LowerSequenceNum)
+		for _, l := range c.Lengths {
+			fmt.Printf("\t%d: %d found, %d invalid, density %.4f, space %d-%d\n",
+				l.ShortcodeLen, l.Found, l.Invalid, l.Density, l.Space.Lower, l.Space.Upper)
+			for i, r := range l.Ranges {
+				fmt.Printf("\t\tscanned %d-%d\n", r.Lower, r.Upper)
+				if i < len(l.Gaps) {
+					fmt.Printf("\t\tgap     %d-%d\n", l.Gaps[i].Lower, l.Gaps[i].Upper)
+				}
+			}
+		}
+	}
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
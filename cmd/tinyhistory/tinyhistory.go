@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to report, e.g., bitly_*")
+	asJSON := flag.Bool("json", false, "print the settings of every release as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Reports changes to project settings that affect how links are interpreted.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := &tinytown.ProcessOptions{}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+	histories, err := tinytown.GetMetaHistory(flag.Arg(0), options)
+	try(err)
+	if *asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		try(e.Encode(histories))
+		return
+	}
+	for _, h := range histories {
+		first, last := h.Releases[0], h.Releases[len(h.Releases)-1]
+		fmt.Printf("%s: %d releases from %s to %s, %d changes\n",
+			h.Project, len(h.Releases), first.Release, last.Release, len(h.Changes))
+		for _, c := range h.Changes {
+			fmt.Printf("\t%s %s: %s %v -> %v\n", c.Time.Format("2006-01-02"), c.Release, c.Field, quote(c.Old), quote(c.New))
+		}
+	}
+}
+
+func quote(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return v
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
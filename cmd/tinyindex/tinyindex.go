@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to index, e.g., bitly_*")
+	skip := flag.String("skip", "", "comma-separated project name patterns to skip")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR INDEXDIR\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir, indexDir := flag.Arg(0), flag.Arg(1)
+
+	idx, err := tinytown.OpenIndex(indexDir, dir)
+	try(err)
+	n, err := idx.Update(&tinytown.ProcessOptions{
+		Projects:     splitList(*projects),
+		SkipProjects: splitList(*skip),
+	})
+	fmt.Fprintf(os.Stderr, "Indexed %d project zips\n", n)
+	try(err)
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
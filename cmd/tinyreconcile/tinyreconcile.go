@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to process, e.g., bitly_*")
+	tracker := flag.String("tracker", tinytown.Tracker, "base URL of the tracker")
+	pending := flag.Bool("pending", false, "subtract results pending export from the tracker found counts; requires tracker admin login")
+	user := flag.String("user", "", "tracker admin user, for -pending")
+	password := flag.String("password", "", "tracker admin password, for -pending")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Compares the projects of the tracker with the downloaded releases and writes discrepancies as CSV.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	tinytown.Tracker = *tracker
+
+	health, err := tinytown.GetHealth()
+	try(err)
+	var pendingResults map[string]int64
+	if *pending {
+		try(tinytown.TrackerLogin(*user, *password))
+		status, err := tinytown.GetReleaseStatus()
+		try(err)
+		pendingResults = status.ProjectResults
+	}
+
+	options := &tinytown.ProcessOptions{Workers: *workers, Observer: progress.NewWriter(os.Stderr)}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+	discrepancies, err := tinytown.ReconcileTracker(flag.Arg(0), health, pendingResults, options)
+	try(err)
+	try(tinytown.WriteDiscrepanciesCSV(os.Stdout, discrepancies))
+
+	counts := make(map[tinytown.DiscrepancyKind]int)
+	for _, d := range discrepancies {
+		counts[d.Kind]++
+	}
+	fmt.Fprintf(os.Stderr, "%d without release, %d unlisted, %d found mismatches\n",
+		counts[tinytown.NoRelease], counts[tinytown.Unlisted], counts[tinytown.FoundMismatch])
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/browser/jsonutil"
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	format := flag.String("format", "json", "output format: json or csv")
+	projects := flag.String("projects", "", "comma-separated project name patterns to process, e.g., bitly_*")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] SNAPSHOT [NEW_SNAPSHOT]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "A snapshot is a release directory or stats saved as JSON.")
+		fmt.Fprintln(os.Stderr, "With two snapshots, the rows that differ are printed.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 && flag.NArg() != 2 || *format != "json" && *format != "csv" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	options := &tinytown.ProcessOptions{
+		Workers:   *workers,
+		Unordered: true,
+		Observer:  progress.NewWriter(os.Stderr),
+	}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+
+	stats, err := loadStats(flag.Arg(0), options)
+	try(err)
+	if flag.NArg() == 1 {
+		if *format == "csv" {
+			try(stats.WriteCSV(os.Stdout))
+			return
+		}
+		try(writeJSON(stats))
+		return
+	}
+	newStats, err := loadStats(flag.Arg(1), options)
+	try(err)
+	diffs := tinytown.CompareStats(stats, newStats)
+	if *format == "csv" {
+		try(tinytown.WriteStatsDiffCSV(os.Stdout, diffs))
+		return
+	}
+	try(writeJSON(diffs))
+}
+
+// loadStats computes the stats of a release directory or reads stats
+// saved as JSON.
+func loadStats(snapshot string, options *tinytown.ProcessOptions) (*tinytown.Stats, error) {
+	fi, err := os.Stat(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return tinytown.GetStats(snapshot, options)
+	}
+	var stats tinytown.Stats
+	if err := jsonutil.DecodeFile(snapshot, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func writeJSON(v interface{}) error {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/andrewarchi/urlhero/tinytown"
+)
+
+func main() {
+	projects := flag.String("projects", "", "comma-separated project name patterns to process, e.g., bitly_*")
+	all := flag.Bool("all", false, "also report dumps without problems")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Validates the links in each dump against the project settings and writes counts of problems as CSV.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := &tinytown.ProcessOptions{Workers: *workers, Observer: progress.NewWriter(os.Stderr)}
+	if *projects != "" {
+		options.Projects = strings.Split(*projects, ",")
+	}
+	dumps, err := tinytown.ValidateReleases(flag.Arg(0), options)
+	try(err)
+
+	reported := dumps[:0]
+	var problems, problemDumps int64
+	for _, d := range dumps {
+		n := d.Validation.Problems()
+		problems += n
+		if n != 0 || d.Validation.AntiRegexErr != "" {
+			problemDumps++
+		} else if !*all {
+			continue
+		}
+		reported = append(reported, d)
+	}
+	try(tinytown.WriteValidationCSV(os.Stdout, reported))
+	fmt.Fprintf(os.Stderr, "%d problems in %d of %d dumps\n", problems, problemDumps, len(dumps))
+}
+
+func try(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -7,30 +7,147 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
 	"github.com/andrewarchi/urlhero/tinytown"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s DIR PATTERN\n", os.Args[0])
+	projects := flag.String("projects", "", "comma-separated project name patterns to process, e.g., bitly_*")
+	skip := flag.String("skip", "", "comma-separated project name patterns to skip")
+	lens := flag.String("lens", "", "comma-separated shortcode lengths to process")
+	start := flag.String("start", "", "earliest release date to process, e.g., 2016-01-01")
+	end := flag.String("end", "", "release date to stop before, e.g., 2017-01-01")
+	workers := flag.Int("workers", 1, "number of link dumps to decode concurrently")
+	unordered := flag.Bool("unordered", false, "print matches as found, instead of in release order")
+	out := flag.String("o", "", "file to write matches to; defaults to stdout")
+	checkpoint := flag.String("checkpoint", "", "state file to resume an interrupted scan from; requires -o")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] DIR|URL PATTERN\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "A URL is of a project zip, which is read with HTTP range requests.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || *checkpoint != "" && *out == "" {
+		flag.Usage()
 		os.Exit(2)
 	}
-	dir, pattern := os.Args[1], os.Args[2]
+	dir, pattern := flag.Arg(0), flag.Arg(1)
 	re, err := regexp.Compile(pattern)
 	try(err)
 
+	options := &tinytown.ProcessOptions{
+		Projects:     splitList(*projects),
+		SkipProjects: splitList(*skip),
+		Workers:      *workers,
+		Unordered:    *unordered,
+		Observer:     progress.NewWriter(os.Stderr),
+	}
+	for _, l := range splitList(*lens) {
+		n, err := strconv.Atoi(l)
+		try(err)
+		options.ShortcodeLens = append(options.ShortcodeLens, n)
+	}
+	options.Start, err = parseDate(*start)
+	try(err)
+	options.End, err = parseDate(*end)
+	try(err)
+
+	w := bufio.NewWriter(os.Stdout)
+	if *out != "" {
+		var cp *tinytown.Checkpoint
+		if *checkpoint != "" {
+			cp, err = tinytown.OpenCheckpoint(*checkpoint)
+			try(err)
+		}
+		f, err := openOutput(*out, cp)
+		try(err)
+		defer f.Close()
+		w = bufio.NewWriter(f)
+		if cp != nil {
+			// Save the size of the output with each checkpoint, so that
+			// matches after the checkpoint are discarded when resuming.
+			cp.Save = func() ([]byte, error) {
+				if err := w.Flush(); err != nil {
+					return nil, err
+				}
+				if err := f.Sync(); err != nil {
+					return nil, err
+				}
+				size, err := f.Seek(0, io.SeekCurrent)
+				return []byte(strconv.FormatInt(size, 10)), err
+			}
+			cp.Offsets = true
+			options.Checkpoint = cp
+		}
+	}
+
 	processLink := func(l *beacon.Link, m *tinytown.Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
 		if re.MatchString(l.Target) {
-			fmt.Println(l.Target)
+			w.WriteString(l.Target)
+			w.WriteByte('\n')
+		}
+		return nil
+	}
+	if tinytown.IsURL(dir) {
+		err = tinytown.ProcessProject(dir, options, processLink)
+	} else {
+		err = tinytown.ProcessReleases(dir, options, processLink)
+	}
+	try(w.Flush())
+	try(err)
+}
+
+// openOutput opens the output file. With a checkpoint, the output is
+// truncated to its size at the last checkpoint.
+func openOutput(filename string, cp *tinytown.Checkpoint) (*os.File, error) {
+	if cp == nil {
+		return os.Create(filename)
+	}
+	var size int64
+	if state := cp.State(); state != nil {
+		var err error
+		if size, err = strconv.ParseInt(string(state), 10, 64); err != nil {
+			return nil, err
 		}
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func splitList(list string) []string {
+	if list == "" {
 		return nil
 	}
-	try(tinytown.ProcessReleases(dir, processLink))
+	return strings.Split(list, ",")
+}
+
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", date)
 }
 
 func try(err error) {
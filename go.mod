@@ -6,6 +6,8 @@ require (
 	github.com/anacrolix/torrent v1.25.1
 	github.com/andrewarchi/archive v0.0.0-20210213193640-3a6449eed2ec
 	github.com/andrewarchi/browser v0.0.0-20210409211550-aeb39920c5c7
+	github.com/hekmon/cunits/v2 v2.0.2
 	github.com/hekmon/transmissionrpc v1.1.0
+	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
 )
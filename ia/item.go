@@ -8,6 +8,7 @@ package ia
 
 import (
 	"encoding/xml"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -15,17 +16,18 @@ import (
 // ItemMeta contains item metadata in the *_meta.xml file in the root of
 // an item.
 type ItemMeta struct {
+	XMLName        xml.Name `xml:"metadata"`
 	Identifier     string   `xml:"identifier"`
 	Collections    []string `xml:"collection"`
-	Description    string   `xml:"description"`
+	Description    string   `xml:"description,omitempty"`
 	Mediatype      string   `xml:"mediatype"` // e.g., "software"
-	Subject        string   `xml:"subject"`
-	Title          string   `xml:"title"`
-	Uploader       string   `xml:"uploader"`
-	Publicdate     string   `xml:"publicdate"` // "2006-01-02 15:04:05" format
-	Addeddate      string   `xml:"addeddate"`  // "2006-01-02 15:04:05" format
-	Curation       string   `xml:"curation"`
-	BackupLocation string   `xml:"backup_location"` // removed from meta in April 2020
+	Subject        string   `xml:"subject,omitempty"`
+	Title          string   `xml:"title,omitempty"`
+	Uploader       string   `xml:"uploader,omitempty"`
+	Publicdate     string   `xml:"publicdate,omitempty"` // "2006-01-02 15:04:05" format
+	Addeddate      string   `xml:"addeddate,omitempty"`  // "2006-01-02 15:04:05" format
+	Curation       string   `xml:"curation,omitempty"`
+	BackupLocation string   `xml:"backup_location,omitempty"` // removed from meta in April 2020
 }
 
 func ReadItemMeta(dir string) (*ItemMeta, error) {
@@ -42,6 +44,16 @@ func ReadItemMeta(dir string) (*ItemMeta, error) {
 	return &meta, nil
 }
 
+// WriteItemMeta writes the item metadata to the *_meta.xml file in the
+// root of an item.
+func WriteItemMeta(dir string, meta *ItemMeta) error {
+	name := filepath.Base(dir) + "_meta.xml"
+	if meta.Identifier != filepath.Base(dir) {
+		return fmt.Errorf("ia: identifier %q does not match directory %s", meta.Identifier, dir)
+	}
+	return writeXML(filepath.Join(dir, name), meta)
+}
+
 const TimestampFormat = "20060102150405"
 
 func PageURL(url, timestamp string) string {
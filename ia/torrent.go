@@ -0,0 +1,271 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ia
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+// Torrent contains the metainfo in the *_archive.torrent file of an
+// item. The torrent excludes the *_files.xml and *_archive.torrent
+// files.
+type Torrent struct {
+	InfoHash    [20]byte
+	Name        string // item identifier
+	PieceLength int64
+	Pieces      [][20]byte // SHA-1 hash of each piece
+	Files       []TorrentFile
+	WebSeeds    []string
+}
+
+// TorrentFile is a file in a torrent. Pieces span file boundaries, so
+// a file begins at Offset in the concatenation of all files.
+type TorrentFile struct {
+	Name   string // filename, relative to root
+	Size   int64
+	Offset int64
+}
+
+// ReadTorrent reads the metainfo in a *_archive.torrent file.
+func ReadTorrent(filename string) (*Torrent, error) {
+	mi, err := metainfo.LoadFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("ia: torrent pieces not a multiple of %d bytes: %s", sha1.Size, filename)
+	}
+
+	t := &Torrent{
+		InfoHash:    mi.HashInfoBytes(),
+		Name:        info.Name,
+		PieceLength: info.PieceLength,
+		Pieces:      make([][20]byte, len(info.Pieces)/sha1.Size),
+		WebSeeds:    mi.UrlList,
+	}
+	for i := range t.Pieces {
+		copy(t.Pieces[i][:], info.Pieces[i*sha1.Size:])
+	}
+	var offset int64
+	for _, fi := range info.UpvertedFiles() {
+		name := path.Join(fi.Path...)
+		if len(fi.Path) == 0 {
+			name = info.Name
+		}
+		t.Files = append(t.Files, TorrentFile{Name: name, Size: fi.Length, Offset: offset})
+		offset += fi.Length
+	}
+	if err := t.checkPieces(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filename)
+	}
+	return t, nil
+}
+
+// ReadItemTorrent reads the *_archive.torrent file of an item. The
+// torrent is looked for in the item directory and, for items
+// downloaded via torrent, next to it.
+func ReadItemTorrent(dir string) (*Torrent, error) {
+	name := filepath.Base(dir) + "_archive.torrent"
+	filename := filepath.Join(dir, name)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		filename = filepath.Join(filepath.Dir(dir), name)
+	}
+	return ReadTorrent(filename)
+}
+
+// TotalSize returns the sum of the sizes of all files in the torrent.
+func (t *Torrent) TotalSize() int64 {
+	if len(t.Files) == 0 {
+		return 0
+	}
+	last := t.Files[len(t.Files)-1]
+	return last.Offset + last.Size
+}
+
+// checkPieces checks that there is a piece hash for every piece of the
+// files.
+func (t *Torrent) checkPieces() error {
+	if t.PieceLength <= 0 {
+		return fmt.Errorf("ia: torrent piece length %d not positive", t.PieceLength)
+	}
+	total := t.TotalSize()
+	if want := (total + t.PieceLength - 1) / t.PieceLength; int64(len(t.Pieces)) != want {
+		return fmt.Errorf("ia: torrent has %d pieces instead of %d for %d bytes", len(t.Pieces), want, total)
+	}
+	return nil
+}
+
+// CheckFileMeta cross-checks the torrent with the file metadata of
+// the item. The info-hash must match the BTIH of the torrent file and
+// the size of each file in the torrent must match its metadata.
+func (t *Torrent) CheckFileMeta(files []FileMeta) error {
+	metas := make(map[string]*FileMeta, len(files))
+	for i := range files {
+		metas[files[i].Name] = &files[i]
+	}
+
+	torrentName := t.Name + "_archive.torrent"
+	fm, ok := metas[torrentName]
+	if !ok {
+		return fmt.Errorf("ia: torrent not in file metadata: %s", torrentName)
+	}
+	if !bytes.Equal(fm.BTIH, t.InfoHash[:]) {
+		return fmt.Errorf("ia: torrent %s: info-hash is %x instead of %x", torrentName, t.InfoHash, []byte(fm.BTIH))
+	}
+	for _, f := range t.Files {
+		fm, ok := metas[f.Name]
+		if !ok {
+			return fmt.Errorf("ia: torrent %s: file not in file metadata: %s", torrentName, f.Name)
+		}
+		if f.Size != fm.Size {
+			return fmt.Errorf("ia: torrent %s: %s is %d bytes instead of %d", torrentName, f.Name, f.Size, fm.Size)
+		}
+	}
+	return nil
+}
+
+// Verify checks the files in the item directory against the piece
+// hashes of the torrent. This validates items downloaded via torrent,
+// which have no *_files.xml. The observer, if non-nil, is notified as
+// each file is checked; since pieces span files, a file is finished
+// once every piece overlapping it has been verified.
+func (t *Torrent) Verify(dir string, obs progress.Observer) error {
+	if err := t.checkPieces(); err != nil {
+		return err
+	}
+	for _, f := range t.Files {
+		fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Name)))
+		if err != nil {
+			return err
+		}
+		if fi.Size() != f.Size {
+			return fmt.Errorf("ia: verify %s: size is %d instead of %d", f.Name, fi.Size(), f.Size)
+		}
+	}
+
+	obs = progress.Or(obs)
+	events := make([]*progress.Event, len(t.Files))
+	started, finished := 0, 0
+	// start starts the files beginning before end.
+	start := func(end int64) {
+		for ; started < len(t.Files); started++ {
+			f := t.Files[started]
+			if f.Offset >= end {
+				break
+			}
+			events[started] = &progress.Event{Kind: progress.File, Name: f.Name, Index: started, Total: len(t.Files), TotalBytes: f.Size}
+			obs.Start(events[started])
+		}
+	}
+	// verified finishes the started files that end by end and updates
+	// the rest.
+	verified := func(end int64) {
+		for ; finished < started && t.Files[finished].Offset+t.Files[finished].Size <= end; finished++ {
+			events[finished].Bytes = t.Files[finished].Size
+			obs.Finish(events[finished], nil)
+		}
+		for _, e := range events[finished:started] {
+			e.Bytes = end - t.Files[e.Index].Offset
+			obs.Update(e)
+		}
+	}
+	fail := func(err error) error {
+		for _, e := range events[finished:started] {
+			obs.Finish(e, err)
+		}
+		return err
+	}
+
+	r := &torrentReader{dir: dir, files: t.Files}
+	defer r.Close()
+	total := t.TotalSize()
+	buf := make([]byte, t.PieceLength)
+	h := sha1.New()
+	for i, want := range t.Pieces {
+		offset := int64(i) * t.PieceLength
+		n := t.PieceLength
+		if offset+n > total {
+			n = total - offset
+		}
+		start(offset + n)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return fail(err)
+		}
+		h.Reset()
+		h.Write(buf[:n])
+		if sum := h.Sum(nil); !bytes.Equal(sum, want[:]) {
+			return fail(fmt.Errorf("ia: verify %s: piece %d SHA-1 sum is %x instead of %x", t.fileAt(offset), i, sum, want))
+		}
+		verified(offset + n)
+	}
+	start(total + 1) // empty files at the end
+	verified(total)
+	return nil
+}
+
+// fileAt returns the name of the file containing the given offset.
+func (t *Torrent) fileAt(offset int64) string {
+	for _, f := range t.Files {
+		if offset < f.Offset+f.Size {
+			return f.Name
+		}
+	}
+	return t.Name
+}
+
+// torrentReader reads the concatenation of the files in a torrent.
+type torrentReader struct {
+	dir   string
+	files []TorrentFile
+	f     *os.File
+}
+
+func (tr *torrentReader) Read(p []byte) (int, error) {
+	for {
+		if tr.f == nil {
+			if len(tr.files) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(tr.dir, filepath.FromSlash(tr.files[0].Name)))
+			if err != nil {
+				return 0, err
+			}
+			tr.f = f
+			tr.files = tr.files[1:]
+		}
+		n, err := tr.f.Read(p)
+		if err == io.EOF {
+			tr.f.Close()
+			tr.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (tr *torrentReader) Close() error {
+	if tr.f != nil {
+		return tr.f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+func TestTorrentVerify(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "example-item")
+	files := map[string]string{
+		"a.txt":     "The quick brown fox jumps over the lazy dog",
+		"b/c.txt":   "Lorem ipsum dolor sit amet",
+		"d.meta.gz": "",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := metainfo.Info{PieceLength: 16}
+	if err := info.BuildFromFilePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes}
+	f, err := os.Create(filepath.Join(root, "example-item_archive.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mi.Write(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tor, err := ReadItemTorrent(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tor.Name != "example-item" || len(tor.Files) != 3 || len(tor.Pieces) != 5 {
+		t.Fatalf("got torrent %s with %d files and %d pieces, want example-item with 3 files and 5 pieces",
+			tor.Name, len(tor.Files), len(tor.Pieces))
+	}
+	if tor.InfoHash != mi.HashInfoBytes() {
+		t.Errorf("got info-hash %x, want %x", tor.InfoHash, mi.HashInfoBytes())
+	}
+
+	fm := []FileMeta{
+		{Name: "example-item_archive.torrent", BTIH: tor.InfoHash[:]},
+		{Name: "a.txt", Size: 43},
+		{Name: "b/c.txt", Size: 26},
+		{Name: "d.meta.gz", Size: 0},
+	}
+	if err := tor.CheckFileMeta(fm); err != nil {
+		t.Error(err)
+	}
+	fm[2].Size = 27
+	if err := tor.CheckFileMeta(fm); err == nil {
+		t.Error("CheckFileMeta: expected size mismatch")
+	}
+
+	var obs finishObserver
+	if err := Validate(dir, &obs); err != nil {
+		t.Error(err)
+	}
+	if want := []string{"a.txt 43 <nil>", "b/c.txt 26 <nil>", "d.meta.gz 0 <nil>"}; !reflect.DeepEqual([]string(obs), want) {
+		t.Errorf("Validate: got events %q, want %q", obs, want)
+	}
+	pieces := tor.Pieces
+	tor.Pieces = append(pieces[:len(pieces):len(pieces)], [20]byte{})
+	if err := tor.Verify(dir, nil); err == nil {
+		t.Error("Verify: expected error for extra piece")
+	}
+	tor.Pieces = pieces[:len(pieces)-1]
+	if err := tor.Verify(dir, nil); err == nil {
+		t.Error("Verify: expected error for missing piece")
+	}
+	tor.Pieces = pieces
+	if err := os.WriteFile(filepath.Join(dir, "b", "c.txt"), []byte("Lorem ipsum dolor sit amen"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	obs = nil
+	if err := Validate(dir, &obs); err == nil {
+		t.Error("Validate: expected piece hash mismatch")
+	}
+	if len(obs) != 2 || obs[0] != "a.txt 43 <nil>" || !strings.HasPrefix(obs[1], "b/c.txt 21 ia: verify b/c.txt: piece 4") {
+		t.Errorf("Validate: got events %q, want a.txt verified and b/c.txt failed", obs)
+	}
+
+	// With *_files.xml, the torrent is checked against its info-hash.
+	if err := os.WriteFile(filepath.Join(dir, "b", "c.txt"), []byte(files["b/c.txt"]), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "example-item_archive.torrent"), filepath.Join(dir, "example-item_archive.torrent")); err != nil {
+		t.Fatal(err)
+	}
+	fm, err = ScanFileMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileMeta(dir, fm); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(dir, nil); err != nil {
+		t.Error(err)
+	}
+	for i := range fm {
+		if fm[i].Name == "example-item_archive.torrent" {
+			fm[i].BTIH = make([]byte, 20)
+		}
+	}
+	if err := WriteFileMeta(dir, fm); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(dir, nil); err == nil || !strings.Contains(err.Error(), "info-hash") {
+		t.Errorf("Validate: got %v, want info-hash mismatch", err)
+	}
+}
+
+// finishObserver records the name, bytes, and error of finished items.
+type finishObserver []string
+
+func (o *finishObserver) Start(e *progress.Event)  {}
+func (o *finishObserver) Update(e *progress.Event) {}
+func (o *finishObserver) Finish(e *progress.Event, err error) {
+	*o = append(*o, fmt.Sprintf("%s %d %v", e.Name, e.Bytes, err))
+}
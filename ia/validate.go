@@ -17,30 +17,70 @@ import (
 	"hash"
 	"hash/crc32"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/andrewarchi/browser/jsonutil"
 	"github.com/andrewarchi/browser/jsonutil/timefmt"
+	"github.com/andrewarchi/urlhero/progress"
 )
 
-func Validate(dir string) error {
-	metaName := filepath.Base(dir) + "_files.xml"
+// Validate checks the files in an item directory against the checksums
+// in *_files.xml. When it is absent, as for items downloaded via
+// torrent, the files are checked against the piece hashes of the
+// *_archive.torrent file instead. When both are present, the torrent is
+// checked against its info-hash and file sizes in *_files.xml. The observer, if non-nil, is
+// notified as each file is checked.
+func Validate(dir string, obs progress.Observer) error {
 	files, err := ReadFileMeta(dir)
+	if os.IsNotExist(err) {
+		t, err := ReadItemTorrent(dir)
+		if err != nil {
+			return err
+		}
+		return t.Verify(dir, obs)
+	}
 	if err != nil {
 		return err
 	}
-	for _, file := range files {
-		fmt.Println(file.Name)
+	// A torrent, when present, must be the one listed in *_files.xml.
+	if t, err := ReadItemTorrent(dir); err == nil {
+		if err := t.CheckFileMeta(files); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return ValidateFiles(dir, files, obs)
+}
+
+// ValidateFiles checks the given files in an item directory against
+// their checksums, as for a subset of the files in *_files.xml that was
+// downloaded. The observer, if non-nil, is notified as each file is
+// checked.
+func ValidateFiles(dir string, files []FileMeta, obs progress.Observer) error {
+	metaName := filepath.Base(dir) + "_files.xml"
+	obs = progress.Or(obs)
+	for i, file := range files {
+		e := &progress.Event{Kind: progress.File, Name: file.Name, Index: i, Total: len(files), TotalBytes: file.Size}
 		if file.Name == metaName {
-			continue // checksums of itself are inaccurate
+			e.Message = "skipped: checksums of itself are inaccurate"
+			obs.Finish(e, nil)
+			continue
 		}
+		obs.Start(e)
 		fv, err := file.OpenValidator(dir)
 		if err != nil {
+			obs.Finish(e, err)
 			return err
 		}
-		_, err = io.Copy(io.Discard, fv)
+		n, err := io.Copy(io.Discard, fv)
 		fv.Close()
+		e.Bytes = n
+		obs.Finish(e, err)
 		if err != nil {
 			return err
 		}
@@ -49,7 +89,8 @@ func Validate(dir string) error {
 }
 
 type filesMeta struct {
-	Files []FileMeta `xml:"file"`
+	XMLName xml.Name   `xml:"files"`
+	Files   []FileMeta `xml:"file"`
 }
 
 // FileMeta contains file metadata listed in the *_files.xml file in the
@@ -71,6 +112,48 @@ type FileMeta struct {
 	Private  bool            `xml:"private"`
 }
 
+// MarshalXML implements the xml.Marshaler interface. Elements are
+// ordered and the modification time is encoded as integer seconds, as
+// in files written by the Internet Archive.
+func (fm FileMeta) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type fileMeta struct {
+		Name     string       `xml:"name,attr"`
+		Source   string       `xml:"source,attr"`
+		BTIH     jsonutil.Hex `xml:"btih,omitempty"`
+		ModTime  int64        `xml:"mtime,omitempty"`
+		Size     int64        `xml:"size"`
+		MD5      jsonutil.Hex `xml:"md5,omitempty"`
+		CRC32    jsonutil.Hex `xml:"crc32,omitempty"`
+		SHA1     jsonutil.Hex `xml:"sha1,omitempty"`
+		Format   string       `xml:"format"`
+		Original string       `xml:"original,omitempty"`
+		Length   float64      `xml:"length,omitempty"`
+		Height   int          `xml:"height,omitempty"`
+		Width    int          `xml:"width,omitempty"`
+		Private  bool         `xml:"private,omitempty"`
+	}
+	var mtime int64
+	if !fm.ModTime.IsZero() {
+		mtime = fm.ModTime.Unix()
+	}
+	return e.EncodeElement(fileMeta{
+		Name:     fm.Name,
+		Source:   fm.Source,
+		BTIH:     fm.BTIH,
+		ModTime:  mtime,
+		Size:     fm.Size,
+		MD5:      fm.MD5,
+		CRC32:    fm.CRC32,
+		SHA1:     fm.SHA1,
+		Format:   fm.Format,
+		Original: fm.Original,
+		Length:   fm.Length,
+		Height:   fm.Height,
+		Width:    fm.Width,
+		Private:  fm.Private,
+	}, start)
+}
+
 func ReadFileMeta(dir string) ([]FileMeta, error) {
 	name := filepath.Base(dir) + "_files.xml"
 	f, err := os.Open(filepath.Join(dir, name))
@@ -85,6 +168,147 @@ func ReadFileMeta(dir string) ([]FileMeta, error) {
 	return meta.Files, nil
 }
 
+// ScanFileMeta computes the file metadata of every file in an item
+// directory, excluding *_files.xml. To be listed, *_meta.xml must be
+// written before scanning.
+func ScanFileMeta(dir string) ([]FileMeta, error) {
+	metaName := filepath.Base(dir) + "_files.xml"
+	var files []FileMeta
+	err := filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if name == metaName {
+			return nil
+		}
+		fm, err := computeFileMeta(dir, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, *fm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func computeFileMeta(dir, name string) (*FileMeta, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	md5Hash, sha1Hash, crc32Hash := md5.New(), sha1.New(), crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, crc32Hash), f); err != nil {
+		return nil, err
+	}
+	source, format := fileFormat(filepath.Base(dir), name)
+	fm := &FileMeta{
+		Name:    name,
+		Source:  source,
+		ModTime: timefmt.UnixSec{Time: fi.ModTime()},
+		Size:    fi.Size(),
+		MD5:     md5Hash.Sum(nil),
+		CRC32:   crc32Hash.Sum(nil),
+		SHA1:    sha1Hash.Sum(nil),
+		Format:  format,
+	}
+	if format == "Archive BitTorrent" {
+		t, err := ReadTorrent(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		fm.BTIH = t.InfoHash[:]
+	}
+	return fm, nil
+}
+
+// fileFormat guesses the source and format of a file, as assigned by
+// the Internet Archive.
+func fileFormat(id, name string) (source, format string) {
+	switch name {
+	case id + "_meta.xml", id + "_files.xml":
+		return "original", "Metadata"
+	case id + "_archive.torrent":
+		return "metadata", "Archive BitTorrent"
+	}
+	switch path.Ext(name) {
+	case ".txt":
+		return "original", "Text"
+	case ".json":
+		return "original", "JSON"
+	case ".zip":
+		return "original", "ZIP"
+	}
+	return "original", "Unknown"
+}
+
+// WriteFileMeta writes the file metadata to the *_files.xml file in
+// the root of an item. An entry for *_files.xml itself is added, if
+// not present.
+func WriteFileMeta(dir string, files []FileMeta) error {
+	name := filepath.Base(dir) + "_files.xml"
+	hasSelf := false
+	for _, fm := range files {
+		if fm.Name == name {
+			hasSelf = true
+			break
+		}
+	}
+	if !hasSelf {
+		files = append(files[:len(files):len(files)], FileMeta{
+			Name:    name,
+			Source:  "original",
+			ModTime: timefmt.UnixSec{Time: time.Now()},
+			Format:  "Metadata",
+		})
+	}
+	return writeXML(filepath.Join(dir, name), &filesMeta{Files: files})
+}
+
+// writeXML writes v as indented XML to a temporary file, which then
+// replaces filename, so that an interrupted write does not leave a
+// truncated file.
+func writeXML(filename string, v interface{}) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := encodeXML(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
 func (fm *FileMeta) OpenValidator(dir string) (io.ReadCloser, error) {
 	f, err := os.Open(filepath.Join(dir, fm.Name))
 	if err != nil {
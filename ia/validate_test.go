@@ -0,0 +1,91 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ia
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteItem(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example-item")
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "links.txt"), []byte("abc|https://example.com/\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &ItemMeta{
+		Identifier:  "example-item",
+		Collections: []string{"opensource", "urlteam"},
+		Mediatype:   "software",
+		Title:       "Example item",
+	}
+	if err := WriteItemMeta(dir, meta); err != nil {
+		t.Fatal(err)
+	}
+	meta2, err := ReadItemMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta2.XMLName = xml.Name{}
+	if !reflect.DeepEqual(meta2, meta) {
+		t.Errorf("ReadItemMeta got %+v, want %+v", meta2, meta)
+	}
+
+	files, err := ScanFileMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("ScanFileMeta got %d files, want 2", len(files))
+	}
+	if err := WriteFileMeta(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	files2, err := ReadFileMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files2) != 3 || files2[2].Name != "example-item_files.xml" {
+		t.Fatalf("ReadFileMeta got %+v, want 3 files ending with example-item_files.xml", files2)
+	}
+	for i := range files {
+		f, f2 := files[i], files2[i]
+		if f.Name != f2.Name || f.Format != f2.Format || f.Size != f2.Size ||
+			f.ModTime.Unix() != f2.ModTime.Unix() || !reflect.DeepEqual(f.SHA1, f2.SHA1) {
+			t.Errorf("ReadFileMeta got %+v, want %+v", f2, f)
+		}
+	}
+	if err := Validate(dir, nil); err != nil {
+		t.Error(err)
+	}
+
+	// A failed write leaves the previous file in place.
+	filename := filepath.Join(dir, "example-item_files.xml")
+	before, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeXML(filename, make(chan int)); err == nil {
+		t.Error("writeXML: expected error for unencodable value")
+	}
+	if after, err := os.ReadFile(filename); err != nil || string(after) != string(before) {
+		t.Errorf("writeXML: file changed after failed write: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d files in item, want 3 without temporary files", len(entries))
+	}
+}
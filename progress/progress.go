@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package progress reports the progress of long-running operations, so
+// that commands can render progress while libraries stay silent.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Observer receives the progress of an operation. Calls are not
+// concurrent. A nil Observer is valid wherever one is accepted and
+// discards all events.
+type Observer interface {
+	// Start is called when work on an item begins.
+	Start(e *Event)
+	// Update is called periodically while an item is in progress.
+	Update(e *Event)
+	// Finish is called when an item is done or has failed. Items that
+	// are skipped or fail before starting are finished without being
+	// started.
+	Finish(e *Event, err error)
+}
+
+// Kind is the kind of item that an event is about.
+type Kind uint8
+
+const (
+	Release  Kind = iota // a release item or project zip
+	Dump                 // a link dump in a project
+	Download             // the transfer of a release
+	File                 // a file in an item, such as when validating
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Release:
+		return "release"
+	case Dump:
+		return "dump"
+	case Download:
+		return "download"
+	case File:
+		return "file"
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Event describes the state of an item. Counts that do not apply to
+// the kind of item are zero.
+type Event struct {
+	Kind         Kind
+	Name         string // e.g., a release identifier or "project.zip:xxxxxx.txt.xz"
+	Index, Total int    // position of the item among its siblings; Total is 0 when unknown
+	Bytes        int64  // bytes completed
+	TotalBytes   int64  // bytes when done; 0 when unknown
+	Links        int64  // links read
+	Message      string // detail, such as why an item was skipped
+}
+
+// Nop is an Observer that discards events.
+type Nop struct{}
+
+func (Nop) Start(e *Event)             {}
+func (Nop) Update(e *Event)            {}
+func (Nop) Finish(e *Event, err error) {}
+
+// Or returns o, or Nop when o is nil.
+func Or(o Observer) Observer {
+	if o == nil {
+		return Nop{}
+	}
+	return o
+}
+
+// Writer is an Observer that writes a line of text for every event.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns an Observer that writes events to w as text.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Start(e *Event) {
+	if e.Kind == Dump {
+		return // reported with its link count when finished
+	}
+	w.printf("%s%s%s\n", e.prefix(), e.Name, e.message())
+}
+
+func (w *Writer) Update(e *Event) {
+	w.printf("%s%s: %s\n", e.prefix(), e.Name, e.bytes())
+}
+
+func (w *Writer) Finish(e *Event, err error) {
+	switch {
+	case err != nil:
+		w.printf("%s%s: %v\n", e.prefix(), e.Name, err)
+	case e.Kind == Dump:
+		w.printf("%s [%d links]\n", e.Name, e.Links)
+	case e.Kind == Download:
+		w.printf("%s%s: %s done\n", e.prefix(), e.Name, e.bytes())
+	case e.Message != "":
+		w.printf("%s%s%s\n", e.prefix(), e.Name, e.message())
+	}
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintf(w.w, format, args...)
+}
+
+func (e *Event) prefix() string {
+	if e.Total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("(%d/%d) ", e.Index+1, e.Total)
+}
+
+func (e *Event) message() string {
+	if e.Message == "" {
+		return ""
+	}
+	return ": " + e.Message
+}
+
+func (e *Event) bytes() string {
+	if e.TotalBytes == 0 {
+		return fmt.Sprintf("%d bytes", e.Bytes)
+	}
+	return fmt.Sprintf("%d/%d bytes", e.Bytes, e.TotalBytes)
+}
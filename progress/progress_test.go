@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	dump := &Event{Kind: Dump, Name: "isgd.zip:xxxxxx.txt.xz"}
+	w.Start(dump)
+	dump.Links = 3
+	w.Finish(dump, nil)
+	dl := &Event{Kind: Download, Name: "urlteam_1", Index: 0, Total: 2, TotalBytes: 10}
+	w.Start(dl)
+	dl.Bytes = 4
+	w.Update(dl)
+	w.Finish(dl, errors.New("closed"))
+	w.Finish(&Event{Kind: Release, Name: "urlteam_2", Index: 1, Total: 2, Message: "skipped"}, nil)
+	want := "isgd.zip:xxxxxx.txt.xz [3 links]\n" +
+		"(1/2) urlteam_1\n" +
+		"(1/2) urlteam_1: 4/10 bytes\n" +
+		"(1/2) urlteam_1: closed\n" +
+		"(2/2) urlteam_2: skipped\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	Or(nil).Finish(dump, nil) // must not panic
+}
@@ -61,6 +61,22 @@ func init() {
 	}
 }
 
+// Register adds a shortener to Shorteners and Lookup. Multiple
+// shorteners may share a host, such as URLTeam projects for different
+// shortcode lengths, in which case the host looks up the first
+// registered. Register is not safe for concurrent use.
+func Register(s *Shortener) error {
+	if _, ok := Lookup[s.Name]; ok {
+		return fmt.Errorf("shorteners: multiple shorteners with name %s", s.Name)
+	}
+	Shorteners = append(Shorteners, s)
+	Lookup[s.Name] = s
+	if _, ok := Lookup[s.Host]; !ok {
+		Lookup[s.Host] = s
+	}
+	return nil
+}
+
 // Clean extracts the shortcode from a URL. An empty string is returned
 // when no shortcode can be found.
 func (s *Shortener) Clean(shortURL string) (string, error) {
@@ -0,0 +1,187 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andrewarchi/browser/jsonutil"
+)
+
+// Checkpoint records the link dumps that have been processed in a state
+// file, so that a traversal that is interrupted skips them when it is
+// restarted. Dumps are identified by the base name of their project, so
+// the releases may be moved between runs.
+//
+// The consumer can persist its own state, such as running totals or the
+// size of its output, in the same file, so that it is always consistent
+// with the recorded progress.
+type Checkpoint struct {
+	// Interval is the minimum time between saves; defaults to 1m. The
+	// state is also saved when a traversal completes without error.
+	Interval time.Duration
+	// Offsets enables recording the position within partially processed
+	// link dumps, so that a restart resumes in the middle of a dump
+	// rather than at its start.
+	Offsets bool
+	// Save returns the consumer state to persist with each checkpoint.
+	// It is called from the same goroutine as the ProcessFunc, between
+	// links, and all links before the checkpoint have been processed.
+	Save func() ([]byte, error)
+
+	filename string
+	mu       sync.Mutex // guards state
+	state    checkpointState
+	saved    time.Time
+}
+
+type checkpointState struct {
+	Done     map[string][]string         `json:"done"`              // project -> dumps
+	Offsets  map[string]map[string]int64 `json:"offsets,omitempty"` // project -> dump -> offset of next link
+	Consumer []byte                      `json:"consumer,omitempty"`
+}
+
+// OpenCheckpoint reads the checkpoint in a state file. A missing state
+// file is an empty checkpoint.
+func OpenCheckpoint(filename string) (*Checkpoint, error) {
+	c := &Checkpoint{filename: filename, saved: time.Now()}
+	if err := jsonutil.DecodeFile(filename, &c.state); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if c.state.Done == nil {
+		c.state.Done = make(map[string][]string)
+	}
+	if c.state.Offsets == nil {
+		c.state.Offsets = make(map[string]map[string]int64)
+	}
+	return c, nil
+}
+
+// State returns the consumer state of the last checkpoint, or nil when
+// there is none.
+func (c *Checkpoint) State() []byte {
+	return c.state.Consumer
+}
+
+// Done reports whether a link dump has been processed.
+func (c *Checkpoint) Done(releaseFilename, dumpFilename string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, dump := range c.state.Done[filepath.Base(releaseFilename)] {
+		if dump == dumpFilename {
+			return true
+		}
+	}
+	return false
+}
+
+// offset returns the byte offset at which to resume a link dump.
+func (c *Checkpoint) offset(releaseFilename, dumpFilename string) int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Offsets[filepath.Base(releaseFilename)][dumpFilename]
+}
+
+// progress records that the links in a dump before the offset have been
+// processed.
+func (c *Checkpoint) progress(releaseFilename, dumpFilename string, offset int64) error {
+	if c == nil || !c.Offsets {
+		return nil
+	}
+	c.mu.Lock()
+	project := filepath.Base(releaseFilename)
+	offsets, ok := c.state.Offsets[project]
+	if !ok {
+		offsets = make(map[string]int64)
+		c.state.Offsets[project] = offsets
+	}
+	offsets[dumpFilename] = offset
+	c.mu.Unlock()
+	return c.maybeFlush()
+}
+
+// dumpDone records that all links in a dump have been processed.
+func (c *Checkpoint) dumpDone(releaseFilename, dumpFilename string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	project := filepath.Base(releaseFilename)
+	c.state.Done[project] = append(c.state.Done[project], dumpFilename)
+	if offsets, ok := c.state.Offsets[project]; ok {
+		delete(offsets, dumpFilename)
+		if len(offsets) == 0 {
+			delete(c.state.Offsets, project)
+		}
+	}
+	c.mu.Unlock()
+	return c.maybeFlush()
+}
+
+func (c *Checkpoint) maybeFlush() error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if time.Since(c.saved) < interval {
+		return nil
+	}
+	return c.Flush()
+}
+
+// Flush saves the checkpoint and the consumer state now. The state file
+// is replaced atomically, so an interruption leaves the previous
+// checkpoint intact.
+func (c *Checkpoint) Flush() error {
+	if c == nil {
+		return nil
+	}
+	if c.Save != nil {
+		consumer, err := c.Save()
+		if err != nil {
+			return err
+		}
+		c.state.Consumer = consumer
+	}
+	c.mu.Lock()
+	b, err := json.Marshal(&c.state)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.filename), filepath.Base(c.filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.filename); err != nil {
+		return err
+	}
+	c.saved = time.Now()
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+func TestCheckpoint(t *testing.T) {
+	projects := append([]testProject(nil), testReleases...)
+	var lines []string
+	for i := 0; i < 3*linkBatchSize+10; i++ {
+		lines = append(lines, fmt.Sprintf("%06x|http://example.com/%d", i, i))
+	}
+	projects = append(projects, testProject{"urlteam_2016-02-01-20-17-02",
+		Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{6: lines}})
+	root := writeTestReleases(t, projects)
+	want := collectSources(t, root, nil)
+
+	errStop := errors.New("stop")
+	for _, workers := range []int{1, 4} {
+		for _, offsets := range []bool{false, true} {
+			state := filepath.Join(t.TempDir(), "checkpoint.json")
+			// Stop partway through the large dump, then resume.
+			var got []string
+			for run, stop := range []int{len(want) - 1500, -1} {
+				cp, err := OpenCheckpoint(state)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = nil
+				if s := cp.State(); s != nil {
+					if err := json.Unmarshal(s, &got); err != nil {
+						t.Fatal(err)
+					}
+				}
+				cp.Interval = time.Nanosecond
+				cp.Offsets = offsets
+				cp.Save = func() ([]byte, error) { return json.Marshal(got) }
+				options := &ProcessOptions{Workers: workers, Checkpoint: cp}
+				n := 0
+				err = ProcessReleases(root, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+					if n == stop {
+						return errStop
+					}
+					n++
+					got = append(got, m.Name+":"+l.Source)
+					return nil
+				})
+				if run == 0 && err != errStop || run == 1 && err != nil {
+					t.Fatalf("workers=%d offsets=%t run %d: got error %v", workers, offsets, run, err)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("workers=%d offsets=%t: got %d links, want %d links in order", workers, offsets, len(got), len(want))
+			}
+
+			cp, err := OpenCheckpoint(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cp.Done(filepath.Join(root, "urlteam_2016-02-01-20-17-02", "bitly_6.2016-02-01-20-17-02.zip"), "xxxxxx.txt.xz") {
+				t.Errorf("workers=%d offsets=%t: dump not recorded as done", workers, offsets)
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+// linkBatch is a batch of links decoded from a link dump by a worker.
+// The final batch of a dump carries its summary and any error.
+type linkBatch struct {
+	links           []*beacon.Link
+	meta            *Meta
+	shortcodeLen    int
+	releaseFilename string
+	dumpFilename    string
+	offset          int64 // byte offset of the first link in the decompressed dump
+	summary         *DumpSummary
+	err             error
+}
+
+const (
+	linkBatchSize        = 1024
+	defaultBufferedLinks = 1 << 20
+)
+
+// poolLinks decodes link dumps with a pool of workers and delivers
+// their links to the goroutine calling Next. When the iterator is
+// closed, the remaining work is cancelled.
+//
+// For unordered delivery, workers send batches to a shared channel.
+// For ordered delivery, each dump has its own channel and the channels
+// are queued in traversal order, so the consumer only reads from the
+// earliest unfinished dump, while later dumps are decoded into their
+// channel buffers. At most Workers+2 dump channels are outstanding, so
+// the buffers are sized to share BufferedLinks between them.
+type poolLinks struct {
+	p      *pool
+	cancel context.CancelFunc
+	ch     chan linkBatch // channel of the current dump for ordered delivery
+	b      linkBatch      // current batch
+	i      int            // index of the next link in b
+	inDump bool           // whether b is being delivered
+}
+
+func newPoolLinks(ctx context.Context, src *releaseSource, zips []string, options *ProcessOptions) *poolLinks {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &pool{
+		ctx:     ctx,
+		src:     src,
+		options: options,
+		sem:     make(chan struct{}, options.Workers),
+	}
+	if options.Unordered {
+		p.out = make(chan linkBatch, options.Workers)
+	} else {
+		p.queue = make(chan chan linkBatch, options.Workers)
+		buffered := options.BufferedLinks
+		if buffered <= 0 {
+			buffered = defaultBufferedLinks
+		}
+		p.chanSize = buffered / linkBatchSize / (options.Workers + 2)
+		if p.chanSize < 1 {
+			p.chanSize = 1
+		}
+	}
+	go p.dispatch(zips)
+	return &poolLinks{p: p, cancel: cancel}
+}
+
+func (pl *poolLinks) next(it *LinkIter) bool {
+	options := pl.p.options
+	for {
+		if pl.inDump {
+			b := &pl.b
+			if pl.i < len(b.links) {
+				if pl.i == 0 {
+					if err := options.checkpoint().progress(b.releaseFilename, b.dumpFilename, b.offset); err != nil {
+						it.err = err
+						return false
+					}
+					it.meta, it.shortcodeLen = b.meta, b.shortcodeLen
+					it.releaseFilename, it.dumpFilename = b.releaseFilename, b.dumpFilename
+				}
+				it.link = b.links[pl.i]
+				pl.i++
+				return true
+			}
+			pl.inDump = false
+			if err := pl.finishBatch(); err != nil {
+				it.err = err
+				return false
+			}
+		}
+		b, ok := pl.recv()
+		if !ok {
+			it.err = pl.p.ctx.Err()
+			return false
+		}
+		pl.b, pl.i, pl.inDump = b, 0, true
+	}
+}
+
+// recv receives the next batch in delivery order.
+func (pl *poolLinks) recv() (linkBatch, bool) {
+	if pl.p.options.Unordered {
+		b, ok := <-pl.p.out
+		return b, ok
+	}
+	for {
+		if pl.ch == nil {
+			ch, ok := <-pl.p.queue
+			if !ok {
+				return linkBatch{}, false
+			}
+			pl.ch = ch
+		}
+		if b, ok := <-pl.ch; ok {
+			return b, true
+		}
+		pl.ch = nil
+	}
+}
+
+// finishBatch handles the end of a batch that has been delivered. The
+// final batch of a dump reports its summary.
+func (pl *poolLinks) finishBatch() error {
+	b, options := &pl.b, pl.p.options
+	if b.summary == nil {
+		return b.err // not final or project failed to open
+	}
+	obs := options.observer()
+	e := &progress.Event{
+		Kind:  progress.Dump,
+		Name:  filepath.Base(b.releaseFilename) + ":" + b.dumpFilename,
+		Links: b.summary.Links,
+		Bytes: b.summary.Size,
+	}
+	obs.Start(e)
+	obs.Finish(e, b.err)
+	if b.err != nil {
+		return b.err
+	}
+	options.dumpDone(b.summary)
+	return options.checkpoint().dumpDone(b.releaseFilename, b.dumpFilename)
+}
+
+// close cancels the workers and drains their batches, so that they
+// exit.
+func (pl *poolLinks) close() {
+	pl.cancel()
+	if pl.p.options.Unordered {
+		for range pl.p.out {
+		}
+		return
+	}
+	if pl.ch != nil {
+		for range pl.ch {
+		}
+	}
+	for ch := range pl.p.queue {
+		for range ch {
+		}
+	}
+}
+
+type pool struct {
+	ctx      context.Context
+	src      *releaseSource
+	options  *ProcessOptions
+	sem      chan struct{}       // limits the number of active workers
+	wg       sync.WaitGroup      // active workers
+	out      chan linkBatch      // unordered delivery
+	queue    chan chan linkBatch // ordered delivery
+	chanSize int                 // batches buffered per dump for ordered delivery
+}
+
+// dispatch opens each project and starts a worker for each link
+// dump, once a worker slot is free.
+func (p *pool) dispatch(zips []string) {
+	defer func() {
+		p.wg.Wait()
+		if p.options.Unordered {
+			close(p.out)
+		} else {
+			close(p.queue)
+		}
+	}()
+	for _, filename := range zips {
+		if err := p.dispatchProject(filename); err != nil {
+			p.send(p.newChan(), linkBatch{releaseFilename: filename, err: err}, true)
+			return
+		}
+		if p.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (p *pool) dispatchProject(filename string) error {
+	c, meta, dumps, err := p.src.openProject(filename, p.options)
+	if err != nil {
+		return err
+	}
+	var zwg sync.WaitGroup
+	defer func() {
+		go func() {
+			zwg.Wait()
+			c.Close()
+		}()
+	}()
+	for _, f := range dumps {
+		select {
+		case p.sem <- struct{}{}:
+		case <-p.ctx.Done():
+			return nil
+		}
+		ch := p.newChan()
+		if ch == nil {
+			<-p.sem
+			return nil
+		}
+		zwg.Add(1)
+		p.wg.Add(1)
+		go func(f *projectFile) {
+			defer func() {
+				<-p.sem
+				zwg.Done()
+				p.wg.Done()
+			}()
+			p.decode(f, filename, meta, ch)
+		}(f)
+	}
+	return nil
+}
+
+// newChan returns the channel for the next link dump. For ordered
+// delivery, a new channel is queued; nil is returned when cancelled.
+func (p *pool) newChan() chan linkBatch {
+	if p.options.Unordered {
+		return p.out
+	}
+	ch := make(chan linkBatch, p.chanSize)
+	select {
+	case p.queue <- ch:
+		return ch
+	case <-p.ctx.Done():
+		return nil
+	}
+}
+
+// send sends a batch and reports whether it was sent before
+// cancellation. For ordered delivery, the channel of the final batch of
+// a dump is closed.
+func (p *pool) send(ch chan linkBatch, b linkBatch, final bool) bool {
+	if ch == nil {
+		return false
+	}
+	if final && !p.options.Unordered {
+		defer close(ch)
+	}
+	select {
+	case ch <- b:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// decode reads a link dump and sends its links in batches.
+func (p *pool) decode(f *projectFile, filename string, meta *Meta, ch chan linkBatch) {
+	newBatch := func() linkBatch {
+		return linkBatch{
+			links:           make([]*beacon.Link, 0, linkBatchSize),
+			meta:            meta,
+			shortcodeLen:    dumpShortcodeLen(f.Name),
+			releaseFilename: filename,
+			dumpFilename:    f.Name,
+		}
+	}
+	b := newBatch()
+	cp := p.options.checkpoint()
+	d, err := readLinkDump(f, filename, meta, cp.offset(filename, f.Name), p.options.Validate, func(l *beacon.Link, offset int64) error {
+		if len(b.links) == 0 {
+			b.offset = offset
+		}
+		b.links = append(b.links, l)
+		if len(b.links) == linkBatchSize {
+			if !p.send(ch, b, false) {
+				return p.ctx.Err()
+			}
+			b = newBatch()
+		}
+		return nil
+	})
+	if p.ctx.Err() != nil {
+		if !p.options.Unordered {
+			close(ch)
+		}
+		return
+	}
+	b.summary, b.err = d, err
+	p.send(ch, b, true)
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+// ConflictKind classifies the observations of a shortcode across
+// releases.
+type ConflictKind uint8
+
+const (
+	// Duplicate shortcodes have the same target in every release.
+	Duplicate ConflictKind = iota
+	// TruncatedTarget shortcodes have targets that are all prefixes of
+	// the longest target, as when a target was cut off in a release.
+	TruncatedTarget
+	// ChangedTarget shortcodes have different targets, as when a
+	// shortcode was rescanned after its redirect changed.
+	ChangedTarget
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case Duplicate:
+		return "duplicate"
+	case TruncatedTarget:
+		return "truncated"
+	case ChangedTarget:
+		return "changed"
+	}
+	return fmt.Sprintf("ConflictKind(%d)", uint8(k))
+}
+
+// Conflict is a shortcode of a project that was found in more than one
+// release.
+type Conflict struct {
+	Project      string
+	Shortcode    string
+	Kind         ConflictKind
+	Observations []Observation // in release order, with one per release and target
+}
+
+// Observation is the target of a shortcode in a release.
+type Observation struct {
+	Target  string
+	Release string    // release label, e.g., item identifier
+	Time    time.Time // release time; zero when not in the name
+}
+
+// FindConflicts groups the links in the releases selected by the
+// options by project and shortcode and returns the shortcodes found in
+// more than one release, sorted by project and shortcode. A shortcode
+// found more than once in a release, such as in two dumps, is only a
+// conflict when it is also found in another release. Every link is held
+// in memory, so large scans should be restricted to a project.
+func FindConflicts(root string, options *ProcessOptions) ([]Conflict, error) {
+	type key struct{ project, shortcode string }
+	type observation struct {
+		target  string
+		release int // index in releases
+	}
+	var releases []Observation
+	var releaseIDs []ReleaseID
+	releaseIndex := make(map[string]int)
+	links := make(map[key][]observation)
+	err := ProcessReleases(root, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		i, ok := releaseIndex[releaseFilename]
+		if !ok {
+			i = len(releases)
+			releaseIndex[releaseFilename] = i
+			id, _ := projectRelease(releaseFilename)
+			releases = append(releases, Observation{Release: releaseLabel(releaseFilename), Time: id.Time})
+			releaseIDs = append(releaseIDs, id)
+		}
+		k := key{m.Name, l.Source}
+		links[k] = append(links[k], observation{l.Target, i})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	for k, obs := range links {
+		// Group observations by release, in release order, and drop
+		// repeats of a target within a release.
+		sort.SliceStable(obs, func(i, j int) bool {
+			a, b := obs[i].release, obs[j].release
+			if a == b {
+				return obs[i].target < obs[j].target
+			}
+			if ra, rb := releaseIDs[a], releaseIDs[b]; ra.Before(rb) || rb.Before(ra) {
+				return ra.Before(rb)
+			}
+			return a < b
+		})
+		var unique []observation
+		nreleases := 0
+		for i, o := range obs {
+			if i == 0 || o.release != obs[i-1].release {
+				nreleases++
+			} else if o.target == obs[i-1].target {
+				continue
+			}
+			unique = append(unique, o)
+		}
+		if nreleases < 2 {
+			continue
+		}
+		c := Conflict{Project: k.project, Shortcode: k.shortcode, Observations: make([]Observation, len(unique))}
+		for i, o := range unique {
+			c.Observations[i] = releases[o.release]
+			c.Observations[i].Target = o.target
+		}
+		c.Kind = classifyTargets(c.Observations)
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		return a.Shortcode < b.Shortcode
+	})
+	return conflicts, nil
+}
+
+// classifyTargets classifies the targets of observations of a
+// shortcode.
+func classifyTargets(obs []Observation) ConflictKind {
+	longest := obs[0].Target
+	same := true
+	for _, o := range obs[1:] {
+		if o.Target != obs[0].Target {
+			same = false
+		}
+		if len(o.Target) > len(longest) {
+			longest = o.Target
+		}
+	}
+	if same {
+		return Duplicate
+	}
+	for _, o := range obs {
+		if !strings.HasPrefix(longest, o.Target) {
+			return ChangedTarget
+		}
+	}
+	return TruncatedTarget
+}
+
+// WriteConflictsCSV writes conflicts as CSV with a header, with a row
+// for every observation.
+func WriteConflictsCSV(w io.Writer, conflicts []Conflict) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "shortcode", "kind", "release", "release_time", "target"})
+	for _, c := range conflicts {
+		for _, o := range c.Observations {
+			t := ""
+			if !o.Time.IsZero() {
+				t = o.Time.Format(time.RFC3339)
+			}
+			cw.Write([]string{c.Project, c.Shortcode, c.Kind.String(), o.Release, t, o.Target})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	projects := append(testReleases[:len(testReleases):len(testReleases)],
+		testProject{"urlteam_2016-02-01-20-17-02", Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{
+			5: {"0000a|http://example.com/5"},
+			6: {"00000b|http://example.com/bb", "00000b|http://example.com/bb"},
+		}},
+		// A shortcode repeated within a release is not a conflict.
+		testProject{"urlteam_2016-02-01-20-17-02", Meta{Name: "isgd", Alphabet: "0123456789abcdef"}, map[int][]string{
+			6: {"00000d|http://example.com/d", "00000d|http://example.com/d"},
+		}},
+	)
+	conflicts, err := FindConflicts(writeTestReleases(t, projects), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		project, shortcode string
+		kind               ConflictKind
+		targets            string
+	}
+	want := []summary{
+		{"bitly_6", "00000a", ChangedTarget, "http://example.com/2015 http://example.com/a"},
+		{"bitly_6", "00000b", TruncatedTarget, "http://example.com/b http://example.com/bb"},
+		{"bitly_6", "0000a", Duplicate, "http://example.com/5 http://example.com/5"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("got %d conflicts, want %d: %+v", len(conflicts), len(want), conflicts)
+	}
+	for i, c := range conflicts {
+		var targets []string
+		for _, o := range c.Observations {
+			targets = append(targets, o.Target)
+		}
+		got := summary{c.Project, c.Shortcode, c.Kind, strings.Join(targets, " ")}
+		if got != want[i] {
+			t.Errorf("conflict %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+	if o := conflicts[0].Observations[0]; o.Release != "urlteam_2015-12-31-20-17-02" || o.Time.Year() != 2015 {
+		t.Errorf("observation: got %+v", o)
+	}
+
+	var b bytes.Buffer
+	if err := WriteConflictsCSV(&b, conflicts); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 7 || lines[1] != "bitly_6,00000a,changed,urlteam_2015-12-31-20-17-02,2015-12-31T20:17:02Z,http://example.com/2015" {
+		t.Errorf("CSV: got %q", lines)
+	}
+}
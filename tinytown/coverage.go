@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"sort"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+// SeqRange is an inclusive range of sequence numbers.
+type SeqRange struct {
+	Lower, Upper int64
+}
+
+// Len returns the number of sequence numbers in the range.
+func (r SeqRange) Len() int64 {
+	return r.Upper - r.Lower + 1
+}
+
+// Coverage is the extent of scanning of a project, inferred from the
+// links found in releases.
+type Coverage struct {
+	Project          string
+	Alphabet         string // alphabet of the latest release
+	LowerSequenceNum int64  // of the latest release
+	Lengths          []LengthCoverage
+}
+
+// LengthCoverage is the extent of scanning of the shortcodes of a
+// length in a project. Scanned ranges are the runs of found sequence
+// numbers separated by less than the gap threshold; the gaps are
+// between them.
+type LengthCoverage struct {
+	ShortcodeLen int
+	Space        SeqRange   // all sequence numbers of the length
+	Found        int64      // shortcodes found, counted once per link dump
+	Invalid      int64      // shortcodes not in the alphabet
+	Ranges       []SeqRange // scanned ranges
+	Gaps         []SeqRange // unscanned ranges between scanned ranges
+	Density      float64    // found per sequence number in the scanned ranges
+}
+
+// ScanCoverage reports the scan coverage of each project in the
+// releases selected by the options, sorted by project name. Runs of at
+// least minGap sequence numbers without found links are reported as
+// gaps.
+//
+// The ranges of each link dump are built as its links are read and
+// merged into those of its project once the dump is done, so memory is
+// proportional to the number of ranges, rather than links. Since link
+// dumps are exported separately, a shortcode found in several releases
+// is counted in each.
+func ScanCoverage(root string, options *ProcessOptions, minGap int64) ([]Coverage, error) {
+	if minGap < 1 {
+		minGap = 1
+	}
+	type lengthRanges struct {
+		ranges  []SeqRange
+		found   int64
+		invalid int64
+	}
+	type dumpKey struct{ release, dump string }
+	projects := make(map[string]map[int]*lengthRanges)
+	metas := make(map[string]*Meta)
+	dumps := make(map[dumpKey]*dumpRanges) // dumps being read
+
+	var o ProcessOptions
+	if options != nil {
+		o = *options
+	}
+	done := o.DumpDone
+	o.DumpDone = func(d *DumpSummary) {
+		key := dumpKey{d.ReleaseFilename, d.DumpFilename}
+		if dr, ok := dumps[key]; ok {
+			delete(dumps, key)
+			lengths, ok := projects[d.Meta.Name]
+			if !ok {
+				lengths = make(map[int]*lengthRanges)
+				projects[d.Meta.Name] = lengths
+			}
+			metas[d.Meta.Name] = d.Meta
+			n := dumpShortcodeLen(d.DumpFilename)
+			lr, ok := lengths[n]
+			if !ok {
+				lr = &lengthRanges{}
+				lengths[n] = lr
+			}
+			lr.ranges = mergeSeqRanges(append(lr.ranges, dr.ranges...), minGap)
+			lr.found += dr.found
+			lr.invalid += dr.invalid
+		}
+		if done != nil {
+			done(d)
+		}
+	}
+	err := ProcessReleases(root, &o, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		key := dumpKey{releaseFilename, dumpFilename}
+		dr, ok := dumps[key]
+		if !ok {
+			dr = &dumpRanges{minGap: minGap}
+			dumps[key] = dr
+		}
+		seq, err := m.SequenceNum(l.Source)
+		if err != nil {
+			dr.invalid++
+			return nil
+		}
+		dr.add(seq)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := make([]Coverage, 0, len(projects))
+	for name, lengths := range projects {
+		m := metas[name]
+		c := Coverage{Project: name, Alphabet: m.Alphabet, LowerSequenceNum: m.LowerSequenceNum}
+		for n, lr := range lengths {
+			lc := LengthCoverage{ShortcodeLen: n, Found: lr.found, Invalid: lr.invalid, Ranges: lr.ranges}
+			lc.Space, _ = sequenceRange(m.Alphabet, n)
+			var scanned int64
+			for i, r := range lr.ranges {
+				scanned += r.Len()
+				if i > 0 {
+					lc.Gaps = append(lc.Gaps, SeqRange{lr.ranges[i-1].Upper + 1, r.Lower - 1})
+				}
+			}
+			if scanned != 0 {
+				lc.Density = float64(lc.Found) / float64(scanned)
+			}
+			c.Lengths = append(c.Lengths, lc)
+		}
+		sort.Slice(c.Lengths, func(i, j int) bool {
+			return c.Lengths[i].ShortcodeLen < c.Lengths[j].ShortcodeLen
+		})
+		coverage = append(coverage, c)
+	}
+	sort.Slice(coverage, func(i, j int) bool {
+		return coverage[i].Project < coverage[j].Project
+	})
+	return coverage, nil
+}
+
+// dumpRanges builds the ranges of the sequence numbers in a link dump
+// as they are read. Dumps are nearly sorted, so a sequence number
+// usually extends the last range.
+type dumpRanges struct {
+	minGap  int64
+	ranges  []SeqRange
+	last    int64
+	found   int64
+	invalid int64
+}
+
+func (dr *dumpRanges) add(seq int64) {
+	if len(dr.ranges) != 0 {
+		if seq == dr.last {
+			return // repeated line
+		}
+		r := &dr.ranges[len(dr.ranges)-1]
+		if seq > r.Upper && seq-r.Upper-1 < dr.minGap {
+			r.Upper = seq
+		} else if seq < r.Lower && r.Lower-seq-1 < dr.minGap {
+			r.Lower = seq
+		} else if seq < r.Lower || seq > r.Upper {
+			dr.ranges = append(dr.ranges, SeqRange{seq, seq})
+		}
+	} else {
+		dr.ranges = append(dr.ranges, SeqRange{seq, seq})
+	}
+	dr.last = seq
+	dr.found++
+}
+
+// mergeSeqRanges sorts ranges and merges those separated by less than
+// minGap.
+func mergeSeqRanges(ranges []SeqRange, minGap int64) []SeqRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Lower < ranges[j].Lower })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		m := &merged[len(merged)-1]
+		if r.Lower-m.Upper-1 < minGap {
+			if r.Upper > m.Upper {
+				m.Upper = r.Upper
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
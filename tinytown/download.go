@@ -9,20 +9,101 @@
 package tinytown
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/storage"
 	"github.com/andrewarchi/browser/jsonutil"
+	"github.com/andrewarchi/urlhero/progress"
 )
 
-// DownloadTorrents downloads all terroroftinytown releases via torrent.
-func DownloadTorrents(dir string) error {
+// TorrentOptions controls the downloads of DownloadTorrents. A nil
+// *TorrentOptions downloads every file with the defaults.
+type TorrentOptions struct {
+	Workers int // number of torrents active at once; defaults to 4
+	// Files reports whether to download a file in a release; nil
+	// downloads all files.
+	Files func(release, filename string) bool
+	// NoPeers disables trackers, DHT, PEX, and incoming connections, so
+	// that data is only downloaded from the Internet Archive web seeds in
+	// each torrent.
+	NoPeers bool
+	// PeerFallback is the duration without progress from the web seeds
+	// before a torrent also connects to peers; defaults to 2m. Web seeds
+	// are preferred, because the Internet Archive seeds every release,
+	// while peers of old releases are rare.
+	PeerFallback time.Duration
+	// Verify rehashes data already on disk before downloading, for data
+	// that was not downloaded by this client.
+	Verify       bool
+	PollInterval time.Duration // interval between progress updates; defaults to 10s
+	// Observer is notified of the download of each release, with the
+	// bytes of its wanted files, when it starts, periodically, and when
+	// it finishes.
+	Observer progress.Observer
+	// FileDone is called with the path on disk of each wanted file, as
+	// soon as all of its pieces are verified, while other files continue
+	// downloading. Calls are made from a single goroutine, in order of
+	// completion, and an error stops all downloads. Files for which
+	// FileDone succeeded are recorded in DoneState, so that they are not
+	// passed again after a restart.
+	FileDone func(release, filename string) error
+	// DoneState is the file recording the files passed to FileDone;
+	// defaults to ".filedone" in the download directory.
+	DoneState string
+	// Process, if non-nil, is called with ProcessProject on the links of
+	// each project zip as soon as it completes, as part of FileDone, so
+	// that releases are processed while they download.
+	Process ProcessFunc
+	// ProcessOptions restricts the projects and link dumps passed to
+	// Process.
+	ProcessOptions *ProcessOptions
+}
+
+// DownloadTorrents downloads terroroftinytown releases via torrent,
+// keeping a bounded number of torrents active. Piece completion is
+// stored in dir, so that an interrupted download resumes with the data
+// already downloaded.
+func DownloadTorrents(dir string, options *TorrentOptions) error {
+	var o TorrentOptions
+	if options != nil {
+		o = *options
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 10 * time.Second
+	}
+	if o.PeerFallback <= 0 {
+		o.PeerFallback = 2 * time.Minute
+	}
+	if o.DoneState == "" {
+		o.DoneState = filepath.Join(dir, ".filedone")
+	}
+	if o.Process != nil {
+		fileDone := o.FileDone
+		o.FileDone = func(release, filename string) error {
+			if fileDone != nil {
+				if err := fileDone(release, filename); err != nil {
+					return err
+				}
+			}
+			if !strings.HasSuffix(filename, ".zip") || !o.ProcessOptions.wantFile(filename) {
+				return nil
+			}
+			return ProcessProject(filename, o.ProcessOptions, o.Process)
+		}
+	}
 	ids, err := GetReleaseIDs()
 	if err != nil {
 		return err
@@ -30,35 +111,351 @@ func DownloadTorrents(dir string) error {
 
 	conf := torrent.NewDefaultClientConfig()
 	conf.DataDir = dir
-	conf.DefaultStorage = storage.NewMMap(dir)
+	// The client does not close storage that it did not create, so the
+	// piece completion database is closed here, after the client.
+	st := storage.NewMMap(dir)
+	defer st.Close()
+	conf.DefaultStorage = st
+	if o.NoPeers {
+		conf.DisableTrackers = true
+		conf.NoDHT = true
+		conf.DisablePEX = true
+		conf.DisableTCP = true
+		conf.DisableUTP = true
+		conf.NoDefaultPortForwarding = true
+	}
 	c, err := torrent.NewClient(conf)
 	if err != nil {
 		return err
 	}
+	defer c.Close()
+
+	d := &torrentDownloader{
+		c:        c,
+		dir:      dir,
+		options:  &o,
+		obs:      progress.Or(o.Observer),
+		maxConns: conf.EstablishedConnsPerTorrent,
+	}
+	if o.FileDone == nil {
+		return d.run(ids, d.download)
+	}
+	// Files are processed in the background, so that downloads continue
+	// while a file is processed.
+	if d.done, err = readSyncState(o.DoneState); err != nil {
+		return err
+	}
+	d.queue = newFileQueue()
+	processed := make(chan struct{})
+	go func() {
+		d.processFiles()
+		close(processed)
+	}()
+	d.run(ids, d.download)
+	d.queue.close()
+	<-processed
+	return d.err
+}
 
+// run downloads releases, keeping at most Workers active, and stops
+// starting downloads after the first error.
+func (d *torrentDownloader) run(ids []string, download func(e *progress.Event) error) error {
+	sem := make(chan struct{}, d.options.Workers)
+	var wg sync.WaitGroup
 	for i, id := range ids {
-		fmt.Printf("(%d/%d) Adding %s\n", i+1, len(ids), id)
-		filename, err := saveTorrentFile(id, dir)
-		if err != nil {
-			return err
+		sem <- struct{}{}
+		if d.failed() {
+			<-sem
+			break
 		}
-		t, err := c.AddTorrentFromFile(filename)
-		if err != nil {
-			return err
+		wg.Add(1)
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			e := &progress.Event{Kind: progress.Download, Name: id, Index: i, Total: len(ids)}
+			if err := download(e); err != nil {
+				err = fmt.Errorf("tinytown: %s: %w", id, err)
+				d.finish(e, err)
+				d.fail(err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	return d.err
+}
+
+// ProjectFiles returns a filter for TorrentOptions.Files that selects
+// the zips of the projects matching the patterns, as in ProcessOptions,
+// and all other files, which are small metadata.
+func ProjectFiles(projects, skipProjects []string) func(release, filename string) bool {
+	o := &ProcessOptions{Projects: projects, SkipProjects: skipProjects}
+	return func(release, filename string) bool {
+		return o.wantFile(filename)
+	}
+}
+
+// wantFile reports whether a file in a release is selected: zips by
+// project and other files always.
+func (o *ProcessOptions) wantFile(filename string) bool {
+	name := path.Base(filename)
+	return !strings.HasSuffix(name, ".zip") || o.matchProject(projectName(name))
+}
+
+type torrentDownloader struct {
+	c        *torrent.Client
+	dir      string
+	options  *TorrentOptions
+	obs      progress.Observer
+	maxConns int // peer connections per torrent, once peers are enabled
+
+	mu    sync.Mutex // guards err and serializes observer calls
+	err   error
+	done  map[string]struct{} // files already passed to FileDone, by path relative to dir
+	queue *fileQueue          // files waiting for FileDone
+}
+
+// download downloads the wanted files of a release and drops the
+// torrent once they are complete.
+func (d *torrentDownloader) download(e *progress.Event) error {
+	id := e.Name
+	filename, err := saveTorrentFile(id, d.dir)
+	if err != nil {
+		return err
+	}
+	t, err := d.c.AddTorrentFromFile(filename)
+	if err != nil {
+		return err
+	}
+	defer t.Drop()
+	<-t.GotInfo()
+	if d.options.Verify {
+		t.VerifyData()
+	}
+	// Peers are only connected once the web seeds stop progressing.
+	var ws *webseedPreference
+	if !d.options.NoPeers {
+		t.SetMaxEstablishedConns(0)
+		ws = &webseedPreference{fallback: d.options.PeerFallback}
+	}
+	var files []*torrent.File
+	for _, f := range t.Files() {
+		if d.options.Files == nil || d.options.Files(id, f.DisplayPath()) {
+			f.Download()
+			files = append(files, f)
 		}
-		t.DownloadAll()
-		if i%15 == 14 {
-			c.WaitAll()
+	}
+
+	sub := t.SubscribePieceStateChanges()
+	defer sub.Close()
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+	done := make([]bool, len(files))
+	for _, f := range files {
+		e.TotalBytes += f.Length()
+	}
+	d.start(e)
+	report := false
+	for {
+		e.Bytes = 0
+		for j, f := range files {
+			completed := f.BytesCompleted()
+			e.Bytes += completed
+			if !done[j] && completed == f.Length() {
+				done[j] = true
+				filename := filepath.Join(d.dir, filepath.FromSlash(f.DisplayPath()))
+				if len(t.Info().Files) != 0 {
+					// Multi-file torrents are stored in a directory
+					filename = filepath.Join(d.dir, t.Name(), filepath.FromSlash(f.DisplayPath()))
+				}
+				d.fileDone(id, filename)
+			}
+		}
+		if e.Bytes == e.TotalBytes {
+			d.finish(e, nil)
+			return nil
+		}
+		if ws.update(e.Bytes, time.Now()) {
+			t.SetMaxEstablishedConns(d.maxConns)
+		}
+		if report {
+			d.update(e)
+		}
+		report = false
+		select {
+		case <-ticker.C:
+			report = true
+		case <-sub.Values:
+		case <-t.Closed():
+			return fmt.Errorf("torrent closed before completion")
+		}
+		if d.failed() {
+			d.finish(e, context.Canceled)
+			return nil
 		}
 	}
-	c.WaitAll()
-	return nil
 }
 
+// webseedPreference decides when a torrent downloading from web seeds
+// falls back to peers.
+type webseedPreference struct {
+	fallback   time.Duration
+	bytes      int64     // bytes completed at last progress
+	progressed time.Time // time of last progress
+	peers      bool      // whether peers have been enabled
+}
+
+// update records the bytes completed and reports whether peers should
+// now be enabled, which is once, after no progress for the fallback
+// duration. A nil preference never enables peers.
+func (w *webseedPreference) update(bytes int64, now time.Time) bool {
+	if w == nil || w.peers {
+		return false
+	}
+	if w.progressed.IsZero() || bytes > w.bytes {
+		w.bytes, w.progressed = bytes, now
+		return false
+	}
+	if now.Sub(w.progressed) < w.fallback {
+		return false
+	}
+	w.peers = true
+	return true
+}
+
+// start, update, and finish notify the observer, serialized with other
+// releases.
+func (d *torrentDownloader) start(e *progress.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.obs.Start(e)
+}
+
+func (d *torrentDownloader) update(e *progress.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.obs.Update(e)
+}
+
+func (d *torrentDownloader) finish(e *progress.Event, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.obs.Finish(e, err)
+}
+
+// fileDone queues a completed file for FileDone, unless it has already
+// been passed to FileDone.
+func (d *torrentDownloader) fileDone(release, filename string) {
+	if d.queue == nil {
+		return
+	}
+	if _, ok := d.done[d.doneKey(filename)]; ok {
+		return
+	}
+	d.queue.push(completedFile{release, filename})
+}
+
+// processFiles calls FileDone on each queued file and records it in
+// the state file, until the queue is closed. After an error, the
+// remaining files are skipped.
+func (d *torrentDownloader) processFiles() {
+	for {
+		f, ok := d.queue.pop()
+		if !ok {
+			return
+		}
+		if d.failed() {
+			continue
+		}
+		err := d.options.FileDone(f.release, f.filename)
+		if err == nil {
+			err = appendSyncState(d.options.DoneState, d.doneKey(f.filename))
+		}
+		if err != nil {
+			d.fail(fmt.Errorf("tinytown: %s: %w", f.release, err))
+		}
+	}
+}
+
+func (d *torrentDownloader) doneKey(filename string) string {
+	if rel, err := filepath.Rel(d.dir, filename); err == nil {
+		filename = rel
+	}
+	return filepath.ToSlash(filename)
+}
+
+type completedFile struct {
+	release, filename string
+}
+
+// fileQueue is an unbounded queue of completed files, so that
+// downloads do not wait for files to be processed.
+type fileQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	files  []completedFile
+	closed bool
+}
+
+func newFileQueue() *fileQueue {
+	q := &fileQueue{}
+	q.cond.L = &q.mu
+	return q
+}
+
+func (q *fileQueue) push(f completedFile) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.files = append(q.files, f)
+	q.cond.Signal()
+}
+
+// pop removes the next file, waiting until there is one. It returns
+// false once the queue is closed and empty.
+func (q *fileQueue) pop() (completedFile, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.files) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.files) == 0 {
+		return completedFile{}, false
+	}
+	f := q.files[0]
+	q.files = q.files[1:]
+	return f, true
+}
+
+func (q *fileQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (d *torrentDownloader) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *torrentDownloader) failed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err != nil
+}
+
+// Archive is the base URL of the Internet Archive, from which releases
+// are downloaded. This can be changed to use a mirror.
+var Archive = "https://archive.org"
+
 // GetReleaseIDs queries the Internet Archive for the identifiers of all
-// incremental terroroftinytown releases.
+// incremental terroroftinytown releases, sorted chronologically.
 func GetReleaseIDs() ([]string, error) {
-	url := "https://archive.org/services/search/v1/scrape?q=subject:terroroftinytown&count=10000"
+	url := Archive + "/services/search/v1/scrape?q=subject:terroroftinytown&count=10000"
 	resp, err := httpGet(url)
 	if err != nil {
 		return nil, err
@@ -87,15 +484,20 @@ func GetReleaseIDs() ([]string, error) {
 	for i, item := range items.Items {
 		ids[i] = item.Identifier
 	}
+	SortReleases(ids)
 	return ids, nil
 }
 
 func saveTorrentFile(id, dir string) (string, error) {
-	url := "https://archive.org/download/" + id + "/" + id + "_archive.torrent"
-	filename := filepath.Join(dir, path.Base(url))
-	return filename, saveFile(url, filename)
+	name := id + "_archive.torrent"
+	filename := filepath.Join(dir, name)
+	return filename, saveFile(itemURL(id, name), filename)
 }
 
+// saveFile downloads a file, unless it already exists. The download is
+// written to a temporary file in the same directory and renamed once
+// complete, so that an interrupted download is not mistaken for a
+// complete file.
 func saveFile(url, filename string) error {
 	if _, err := os.Stat(filename); err == nil {
 		return nil
@@ -107,13 +509,24 @@ func saveFile(url, filename string) error {
 	}
 	defer resp.Body.Close()
 
-	f, err := os.Create(filename)
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return err
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
 }
 
 func httpGet(url string) (*http.Response, error) {
@@ -0,0 +1,233 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/andrewarchi/urlhero/beacon"
+
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+func TestProjectFiles(t *testing.T) {
+	want := ProjectFiles([]string{"bitly_*"}, []string{"bitly_7"})
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{"urlteam_2016-01-21-20-17-02/bitly_6.2016-01-21-20-17-02.zip", true},
+		{"urlteam_2016-01-21-20-17-02/bitly_7.2016-01-21-20-17-02.zip", false},
+		{"urlteam_2016-01-21-20-17-02/isgd.2016-01-21-20-17-02.zip", false},
+		{"urlteam_2016-01-21-20-17-02/urlteam_2016-01-21-20-17-02_meta.xml", true},
+	}
+	for _, tt := range tests {
+		if got := want("urlteam_2016-01-21-20-17-02", tt.filename); got != tt.want {
+			t.Errorf("ProjectFiles(%q) = %t, want %t", tt.filename, got, tt.want)
+		}
+	}
+	all := ProjectFiles(nil, nil)
+	if !all("urlteam_2016-01-21-20-17-02", "isgd.2016-01-21-20-17-02.zip") {
+		t.Error("ProjectFiles with no patterns: want all files")
+	}
+}
+
+func TestTorrentDownloaderRun(t *testing.T) {
+	d := &torrentDownloader{options: &TorrentOptions{Workers: 1}, obs: progress.Nop{}}
+	var mu sync.Mutex
+	var started []string
+	errFail := errors.New("fail")
+	err := d.run([]string{"a", "b", "c", "d"}, func(e *progress.Event) error {
+		mu.Lock()
+		started = append(started, e.Name)
+		mu.Unlock()
+		if e.Name == "b" {
+			return errFail
+		}
+		return nil
+	})
+	if !errors.Is(err, errFail) {
+		t.Errorf("got error %v, want %v", err, errFail)
+	}
+	if !reflect.DeepEqual(started, []string{"a", "b"}) {
+		t.Errorf("got started %q, want no downloads after the error", started)
+	}
+
+	d = &torrentDownloader{options: &TorrentOptions{Workers: 2}, obs: progress.Nop{}}
+	var active, maxActive int
+	err = d.run([]string{"a", "b", "c", "d", "e"}, func(e *progress.Event) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || maxActive > 2 {
+		t.Errorf("got %d active at once and error %v, want at most 2", maxActive, err)
+	}
+}
+
+func TestWebseedPreference(t *testing.T) {
+	w := &webseedPreference{fallback: time.Minute}
+	start := time.Now()
+	steps := []struct {
+		bytes int64
+		after time.Duration
+		peers bool
+	}{
+		{0, 0, false},
+		{10, 30 * time.Second, false},
+		{10, 80 * time.Second, false},
+		{10, 91 * time.Second, true},
+		{10, 200 * time.Second, false}, // already enabled
+	}
+	for i, s := range steps {
+		if got := w.update(s.bytes, start.Add(s.after)); got != s.peers {
+			t.Errorf("step %d: got %t, want %t", i, got, s.peers)
+		}
+	}
+	var none *webseedPreference
+	if none.update(0, start.Add(time.Hour)) {
+		t.Error("nil preference: enabled peers")
+	}
+}
+
+func TestDownloadTorrentsFileDone(t *testing.T) {
+	const id = "urlteam_2016-01-21-20-17-02"
+	const pieceLength = 16384
+	remote := t.TempDir()
+	itemDir := filepath.Join(remote, id)
+	if err := os.MkdirAll(itemDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	small, large := "bitly_6.2016-01-21-20-17-02.zip", "isgd.2016-01-21-20-17-02.zip"
+	if err := os.WriteFile(filepath.Join(itemDir, small), bytes.Repeat([]byte("a"), 1000), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(itemDir, large), bytes.Repeat([]byte("b"), 8*pieceLength), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	// The web seed holds back the end of the large zip until the small
+	// zip has been processed, so processing must overlap downloading.
+	smallDone := make(chan struct{})
+	serveTestArchive(t, remote, []string{id}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var start int64
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+			if strings.HasSuffix(r.URL.Path, large) && start >= 2*pieceLength {
+				select {
+				case <-smallDone:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	writeTestTorrent(t, itemDir, pieceLength)
+
+	local := t.TempDir()
+	var processed []string
+	options := &TorrentOptions{
+		NoPeers:      true,
+		PollInterval: 10 * time.Millisecond,
+		FileDone: func(release, filename string) error {
+			processed = append(processed, filepath.Base(filename))
+			if filepath.Base(filename) == small {
+				close(smallDone)
+			}
+			return nil
+		},
+	}
+	if err := DownloadTorrents(local, options); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(processed, []string{small, large}) {
+		t.Fatalf("got processed %q, want %q", processed, []string{small, large})
+	}
+	b, err := os.ReadFile(filepath.Join(local, id, large))
+	if err != nil || len(b) != 8*pieceLength {
+		t.Fatalf("large zip: got %d bytes, %v", len(b), err)
+	}
+
+	// Files already processed are not processed again after a restart.
+	processed = nil
+	if err := DownloadTorrents(local, options); err != nil {
+		t.Fatal(err)
+	}
+	if len(processed) != 0 {
+		t.Errorf("restart: got processed %q, want none", processed)
+	}
+}
+
+// writeTestTorrent writes the torrent of an item directory, with the
+// Archive as web seed.
+func writeTestTorrent(t *testing.T, itemDir string, pieceLength int64) {
+	t.Helper()
+	info := metainfo.Info{PieceLength: pieceLength}
+	if err := info.BuildFromFilePath(itemDir); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes, UrlList: []string{Archive + "/download/"}}
+	f, err := os.Create(filepath.Join(itemDir, filepath.Base(itemDir)+"_archive.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mi.Write(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadTorrentsProcess(t *testing.T) {
+	remote := writeTestReleases(t, testReleases[1:])
+	const id = "urlteam_2016-01-21-20-17-02"
+	serveTestArchive(t, remote, []string{id}, nil)
+	writeTestTorrent(t, filepath.Join(remote, id), 16384)
+
+	var links []string
+	options := &TorrentOptions{
+		NoPeers:        true,
+		PollInterval:   10 * time.Millisecond,
+		ProcessOptions: &ProcessOptions{Projects: []string{"bitly_*"}},
+		Process: func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+			links = append(links, m.Name+":"+l.Source)
+			return nil
+		},
+	}
+	if err := DownloadTorrents(t.TempDir(), options); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bitly_6:0000a", "bitly_6:00000a", "bitly_6:00000b"}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("got links %q, want %q", links, want)
+	}
+}
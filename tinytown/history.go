@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MetaHistory is the settings of a project in each of its releases.
+type MetaHistory struct {
+	Project  string        `json:"project"`
+	Releases []MetaRelease `json:"releases"` // in chronological order
+	Changes  []MetaChange  `json:"changes"`
+}
+
+// MetaRelease is the settings of a project in a release.
+type MetaRelease struct {
+	Release         string    `json:"release"` // release label, e.g., item identifier
+	ReleaseFilename string    `json:"release_filename"`
+	Time            time.Time `json:"time"` // zero when not in the name
+	Meta            *Meta     `json:"meta"`
+
+	id ReleaseID
+}
+
+// MetaChange is a change to a setting of a project that affects how its
+// links are interpreted, from the previous release of the project to
+// the given release.
+type MetaChange struct {
+	Release string      `json:"release"`
+	Time    time.Time   `json:"time"`
+	Field   string      `json:"field"` // JSON name of the Meta field
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+}
+
+// metaHistoryFields are the Meta fields that are compared between
+// releases.
+var metaHistoryFields = []struct {
+	name string
+	get  func(m *Meta) interface{}
+}{
+	{"alphabet", func(m *Meta) interface{} { return m.Alphabet }},
+	{"url_template", func(m *Meta) interface{} { return m.URLTemplate }},
+	{"redirect_codes", func(m *Meta) interface{} { return m.RedirectCodes }},
+	{"no_redirect_codes", func(m *Meta) interface{} { return m.NoRedirectCodes }},
+	{"body_regex", func(m *Meta) interface{} { return m.BodyRegex }},
+	{"method", func(m *Meta) interface{} { return m.Method }},
+}
+
+// GetMetaHistory reads the settings of every project in the releases
+// selected by the options and reports the changes between consecutive
+// releases of each project. Histories are sorted by project name.
+func GetMetaHistory(root string, options *ProcessOptions) ([]MetaHistory, error) {
+	src := dirSource(root)
+	projects, err := src.findProjects(options)
+	if err != nil {
+		return nil, err
+	}
+	histories := make(map[string]*MetaHistory)
+	for _, filename := range projects {
+		c, meta, _, err := src.openProject(filename, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.Close()
+		h, ok := histories[meta.Name]
+		if !ok {
+			h = &MetaHistory{Project: meta.Name}
+			histories[meta.Name] = h
+		}
+		id, _ := projectRelease(filename)
+		h.Releases = append(h.Releases, MetaRelease{
+			Release:         releaseLabel(filename),
+			ReleaseFilename: filename,
+			Time:            id.Time,
+			Meta:            meta,
+			id:              id,
+		})
+	}
+
+	names := make([]string, 0, len(histories))
+	for name := range histories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]MetaHistory, len(names))
+	for i, name := range names {
+		h := histories[name]
+		// Releases with the same timestamp are ordered by sequence number.
+		sort.SliceStable(h.Releases, func(i, j int) bool {
+			return h.Releases[i].id.Before(h.Releases[j].id)
+		})
+		for j := 1; j < len(h.Releases); j++ {
+			h.Changes = append(h.Changes, compareMeta(h.Releases[j-1].Meta, &h.Releases[j])...)
+		}
+		result[i] = *h
+	}
+	return result, nil
+}
+
+func compareMeta(prev *Meta, r *MetaRelease) []MetaChange {
+	var changes []MetaChange
+	for _, f := range metaHistoryFields {
+		old, new := f.get(prev), f.get(r.Meta)
+		if fmt.Sprint(old) != fmt.Sprint(new) {
+			changes = append(changes, MetaChange{
+				Release: r.Release,
+				Time:    r.Time,
+				Field:   f.name,
+				Old:     old,
+				New:     new,
+			})
+		}
+	}
+	return changes
+}
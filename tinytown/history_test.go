@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetMetaHistory(t *testing.T) {
+	projects := append(testReleases[:len(testReleases):len(testReleases)],
+		testProject{"urlteam_2016-02-01-20-17-02", Meta{
+			Name:          "bitly_6",
+			Alphabet:      "0123456789abcdefg",
+			RedirectCodes: []int{301, 302},
+		}, map[int][]string{6: {"00000g|http://example.com/g"}}},
+		testProject{"urlteam_2016-01-21-20-17-02_1", Meta{
+			Name:     "bitly_6",
+			Alphabet: "0123456789abcdef",
+			Method:   "head",
+		}, nil},
+	)
+	histories, err := GetMetaHistory(writeTestReleases(t, projects), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || histories[0].Project != "bitly_6" || histories[1].Project != "isgd" {
+		t.Fatalf("got histories %+v", histories)
+	}
+	h := histories[0]
+	if len(h.Releases) != 4 || h.Releases[0].Release != "urlteam_2015-12-31-20-17-02" ||
+		h.Releases[2].Release != "urlteam_2016-01-21-20-17-02_1" {
+		t.Errorf("releases: got %+v", h.Releases)
+	}
+	want := []string{
+		`urlteam_2016-01-21-20-17-02_1 method "" -> "head"`,
+		`urlteam_2016-02-01-20-17-02 alphabet "0123456789abcdef" -> "0123456789abcdefg"`,
+		`urlteam_2016-02-01-20-17-02 redirect_codes [] -> [301 302]`,
+		`urlteam_2016-02-01-20-17-02 method "head" -> ""`,
+	}
+	if len(h.Changes) != len(want) {
+		t.Fatalf("changes: got %+v", h.Changes)
+	}
+	for i, c := range h.Changes {
+		got := fmt.Sprintf("%s %s %q -> %q", c.Release, c.Field, c.Old, c.New)
+		if c.Field == "redirect_codes" {
+			got = fmt.Sprintf("%s %s %v -> %v", c.Release, c.Field, c.Old, c.New)
+		}
+		if got != want[i] {
+			t.Errorf("change %d: got %s, want %s", i, got, want[i])
+		}
+	}
+	if len(histories[1].Changes) != 0 {
+		t.Errorf("isgd: got changes %+v", histories[1].Changes)
+	}
+}
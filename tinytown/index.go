@@ -0,0 +1,487 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+// Index locates links in releases by project and shortcode, so that
+// lookups do not need to scan every release. The index is a directory
+// with a segment file for each indexed project zip, so new releases can
+// be indexed incrementally.
+//
+// A segment file has the following layout, with big-endian integers:
+//
+//	magic    "ttindex2"
+//	dumps    for each dump:
+//	           targets  target string, for each link
+//	           records  sorted by shortcode:
+//	                      shortcode [shortcode length]byte,
+//	                      offset uint64, target offset uint64
+//	footer   release name, dump count uvarint, and for each dump:
+//	           dump name, shortcode length uvarint,
+//	           record count uvarint, records offset uvarint
+//	trailer  footer offset uint64
+//
+// Strings are prefixed by their length as a uvarint. Targets are stored
+// in the segment, so that lookups do not decompress link dumps.
+// Segments in an older format are reindexed by Update and Add.
+type Index struct {
+	dir  string // index directory
+	root string // release directory
+}
+
+// IndexEntry is a link in a release and its location.
+type IndexEntry struct {
+	Project         string
+	Shortcode       string
+	Target          string
+	ReleaseFilename string
+	DumpFilename    string
+	Offset          int64 // byte offset of the link in the decompressed dump
+}
+
+const indexMagic = "ttindex2"
+
+// OpenIndex opens the index in dir of the releases in root, creating
+// the directory if needed.
+func OpenIndex(dir, root string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	return &Index{dir: dir, root: root}, nil
+}
+
+// Update indexes the project zips selected by the options that have
+// not yet been indexed and returns the number of zips indexed.
+func (idx *Index) Update(options *ProcessOptions) (int, error) {
+	zips, err := dirSource(idx.root).findProjects(options)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, filename := range zips {
+		added, err := idx.Add(filename)
+		if err != nil {
+			return n, err
+		}
+		if added {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Add indexes a project zip within the release directory, unless it has
+// already been indexed, and reports whether it was indexed.
+func (idx *Index) Add(filename string) (bool, error) {
+	segment := idx.segmentFilename(projectName(filename), filename)
+	if current, err := isCurrentSegment(segment); err != nil {
+		return false, err
+	} else if current {
+		return false, nil
+	}
+	if err := idx.indexProject(filename, segment); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (idx *Index) segmentFilename(project, zipFilename string) string {
+	name := strings.TrimSuffix(filepath.Base(zipFilename), ".zip") + ".idx"
+	return filepath.Join(idx.dir, project, name)
+}
+
+// isCurrentSegment reports whether a segment exists in the current
+// format.
+func isCurrentSegment(segment string) (bool, error) {
+	f, err := os.Open(segment)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var b [len(indexMagic)]byte
+	if _, err := io.ReadFull(f, b[:]); err != nil {
+		return false, nil
+	}
+	return string(b[:]) == indexMagic, nil
+}
+
+type indexRecord struct {
+	shortcode string
+	offset    int64 // offset of the link in the dump
+	target    int64 // offset of the target in the segment
+}
+
+type indexDump struct {
+	name         string
+	shortcodeLen int
+	count        int
+	offset       int64
+}
+
+// indexProject writes the segment for a project zip. The segment is
+// written to a temporary file and renamed, so that an interrupted
+// update does not leave a partial segment.
+func (idx *Index) indexProject(filename, segment string) error {
+	release, err := filepath.Rel(idx.root, filename)
+	if err != nil {
+		return err
+	}
+	c, _, dumps, err := dirSource(idx.root).openProject(filename, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := os.MkdirAll(filepath.Dir(segment), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(segment), filepath.Base(segment)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	w := &countWriter{w: bw}
+
+	w.WriteString(indexMagic)
+	var dumpInfo []indexDump
+	for _, dump := range dumps {
+		records, err := writeIndexTargets(w, dump)
+		if err != nil {
+			return err
+		}
+		d := indexDump{name: dump.Name, shortcodeLen: dumpShortcodeLen(dump.Name), count: len(records), offset: w.n}
+		var b [16]byte
+		for _, r := range records {
+			w.WriteString(r.shortcode)
+			binary.BigEndian.PutUint64(b[:8], uint64(r.offset))
+			binary.BigEndian.PutUint64(b[8:], uint64(r.target))
+			w.Write(b[:])
+		}
+		dumpInfo = append(dumpInfo, d)
+	}
+
+	footer := w.n
+	w.writeString(filepath.ToSlash(release))
+	w.writeUvarint(uint64(len(dumpInfo)))
+	for _, d := range dumpInfo {
+		w.writeString(d.name)
+		w.writeUvarint(uint64(d.shortcodeLen))
+		w.writeUvarint(uint64(d.count))
+		w.writeUvarint(uint64(d.offset))
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(footer))
+	w.Write(b[:])
+	if w.err != nil {
+		return w.err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), segment)
+}
+
+// writeIndexTargets writes the target of every link in a link dump to
+// the segment and returns the records of the links, sorted by
+// shortcode.
+func writeIndexTargets(w *countWriter, f *projectFile) ([]indexRecord, error) {
+	dr, err := openLinkDump(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	shortcodeLen := dumpShortcodeLen(f.Name)
+	var records []indexRecord
+	for {
+		link, err := dr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(link.Source) != shortcodeLen {
+			return nil, fmt.Errorf("tinytown: index %s: shortcode not %d characters: %q", f.Name, shortcodeLen, link.Source)
+		}
+		records = append(records, indexRecord{link.Source, dr.Offset(), w.n})
+		w.writeString(link.Target)
+		if w.err != nil {
+			return nil, w.err
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].shortcode < records[j].shortcode
+	})
+	return records, nil
+}
+
+// Lookup returns the locations of all links for a shortcode in the
+// indexed releases of a project, in release order.
+func (idx *Index) Lookup(project, shortcode string) ([]IndexEntry, error) {
+	segments, err := filepath.Glob(filepath.Join(idx.dir, project, "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	// Segments are named by project zip, so are sorted as releases.
+	names := make([]string, len(segments))
+	for i, segment := range segments {
+		names[i] = strings.TrimSuffix(filepath.Base(segment), ".idx")
+	}
+	SortReleases(names)
+	for i, name := range names {
+		segments[i] = filepath.Join(idx.dir, project, name+".idx")
+	}
+	var entries []IndexEntry
+	for _, segment := range segments {
+		e, err := idx.lookupSegment(project, segment, shortcode)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+func (idx *Index) lookupSegment(project, segment, shortcode string) ([]IndexEntry, error) {
+	f, err := os.Open(segment)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	release, dumps, err := readIndexFooter(f, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []IndexEntry
+	for _, d := range dumps {
+		if d.shortcodeLen != len(shortcode) {
+			continue
+		}
+		size := int64(d.shortcodeLen + 16)
+		record := make([]byte, size)
+		var readErr error
+		readRecord := func(i int) []byte {
+			if _, err := f.ReadAt(record, d.offset+int64(i)*size); err != nil && readErr == nil {
+				readErr = err
+			}
+			return record
+		}
+		i := sort.Search(d.count, func(i int) bool {
+			return string(readRecord(i)[:d.shortcodeLen]) >= shortcode
+		})
+		for ; i < d.count && readErr == nil; i++ {
+			r := readRecord(i)
+			if string(r[:d.shortcodeLen]) != shortcode {
+				break
+			}
+			target, err := readIndexTarget(f, int64(binary.BigEndian.Uint64(r[d.shortcodeLen+8:])), d.offset)
+			if err != nil {
+				return nil, fmt.Errorf("tinytown: index segment %s: %w", segment, err)
+			}
+			entries = append(entries, IndexEntry{
+				Project:         project,
+				Shortcode:       shortcode,
+				Target:          target,
+				ReleaseFilename: filepath.Join(idx.root, filepath.FromSlash(release)),
+				DumpFilename:    d.name,
+				Offset:          int64(binary.BigEndian.Uint64(r[d.shortcodeLen:])),
+			})
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	return entries, nil
+}
+
+func readIndexFooter(f *os.File, segment string) (string, []indexDump, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	var b [8]byte
+	if fi.Size() < int64(len(indexMagic)+len(b)) {
+		return "", nil, fmt.Errorf("tinytown: index segment too short: %s", segment)
+	}
+	if _, err := f.ReadAt(b[:], 0); err != nil {
+		return "", nil, err
+	}
+	if string(b[:]) != indexMagic {
+		return "", nil, fmt.Errorf("tinytown: not an index segment: %s", segment)
+	}
+	if _, err := f.ReadAt(b[:], fi.Size()-8); err != nil {
+		return "", nil, err
+	}
+	footer := int64(binary.BigEndian.Uint64(b[:]))
+	if footer < int64(len(indexMagic)) || footer > fi.Size()-8 {
+		return "", nil, fmt.Errorf("tinytown: index segment footer out of range: %s", segment)
+	}
+
+	footerLen := fi.Size() - 8 - footer
+	br := bufio.NewReader(io.NewSectionReader(f, footer, footerLen))
+	release, err := readIndexString(br, footerLen)
+	if err != nil {
+		return "", nil, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", nil, err
+	}
+	// Each dump takes at least 4 bytes of the footer.
+	if n > uint64(footerLen)/4 {
+		return "", nil, fmt.Errorf("tinytown: index segment dump count %d exceeds footer: %s", n, segment)
+	}
+	dumps := make([]indexDump, n)
+	for i := range dumps {
+		d := &dumps[i]
+		if d.name, err = readIndexString(br, footerLen); err != nil {
+			return "", nil, err
+		}
+		var shortcodeLen, count, offset uint64
+		if shortcodeLen, err = binary.ReadUvarint(br); err != nil {
+			return "", nil, err
+		}
+		if count, err = binary.ReadUvarint(br); err != nil {
+			return "", nil, err
+		}
+		if offset, err = binary.ReadUvarint(br); err != nil {
+			return "", nil, err
+		}
+		if offset > uint64(footer) || count > (uint64(footer)-offset)/(shortcodeLen+16) {
+			return "", nil, fmt.Errorf("tinytown: index segment records out of range: %s", segment)
+		}
+		d.shortcodeLen, d.count, d.offset = int(shortcodeLen), int(count), int64(offset)
+	}
+	return release, dumps, nil
+}
+
+// readIndexString reads a length-prefixed string of at most max bytes.
+func readIndexString(br *bufio.Reader, max int64) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(max) {
+		return "", fmt.Errorf("tinytown: index string length %d out of range", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readIndexTarget reads the target at an offset in a segment, before
+// the records of its dump.
+func readIndexTarget(f *os.File, offset, records int64) (string, error) {
+	if offset < int64(len(indexMagic)) || offset >= records {
+		return "", fmt.Errorf("target offset %d out of range", offset)
+	}
+	br := bufio.NewReader(io.NewSectionReader(f, offset, records-offset))
+	return readIndexString(br, records-offset)
+}
+
+// ReadLink reads the link at the location of the entry from its
+// release. The link dump is decompressed up to the offset of the link,
+// so this is much slower than using the target in the entry, but
+// includes the rest of the link.
+func (e *IndexEntry) ReadLink() (*beacon.Link, error) {
+	c, files, err := projectSource(e.ReleaseFilename).openProjectFiles(e.ReleaseFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	for _, f := range files {
+		if f.Name != e.DumpFilename {
+			continue
+		}
+		dr, err := openLinkDump(f, e.Offset)
+		if err != nil {
+			return nil, err
+		}
+		defer dr.Close()
+		return dr.Read()
+	}
+	return nil, fmt.Errorf("tinytown: no dump %s in %s", e.DumpFilename, e.ReleaseFilename)
+}
+
+// Search looks up links with the given shortcodes in the indexed
+// releases of a project, like SearchReleases. Full short URLs are
+// cleaned to their shortcodes.
+func (idx *Index) Search(project string, shortcodes []string) ([]SearchResult, error) {
+	var results []SearchResult
+	for _, shortcode := range shortcodes {
+		shortcode, err := cleanShortcode(project, shortcode)
+		if err != nil {
+			return results, err
+		}
+		if shortcode == "" {
+			continue
+		}
+		entries, err := idx.Lookup(project, shortcode)
+		if err != nil {
+			return results, err
+		}
+		for _, e := range entries {
+			link := &beacon.Link{Source: e.Shortcode, Target: e.Target}
+			results = append(results, SearchResult{link, project, e.ReleaseFilename, e.DumpFilename})
+		}
+	}
+	return results, nil
+}
+
+// countWriter counts the bytes written and saves the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func (cw *countWriter) WriteString(s string) (int, error) {
+	return cw.Write([]byte(s))
+}
+
+func (cw *countWriter) writeString(s string) {
+	cw.writeUvarint(uint64(len(s)))
+	cw.WriteString(s)
+}
+
+func (cw *countWriter) writeUvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	cw.Write(b[:binary.PutUvarint(b[:], x)])
+}
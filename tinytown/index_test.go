@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	idx, err := OpenIndex(t.TempDir(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idx.Update(nil); err != nil || n != 3 {
+		t.Fatalf("Update: got %d, %v, want 3 zips indexed", n, err)
+	}
+
+	results, err := idx.Search("bitly_6", []string{"00000a", "https://bit.ly/00000b?x=1", "zzzzzzz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://example.com/2015", "http://example.com/a", "http://example.com/b"}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Project != "bitly_6" || r.Link.Target != want[i] {
+			t.Errorf("#%d: got %s %v, want bitly_6 %s", i, r.Project, r.Link, want[i])
+		}
+	}
+
+	// A new release is indexed without reindexing the others.
+	p := testProject{"urlteam_2016-02-01-20-17-02", Meta{Name: "isgd", Alphabet: "0123456789abcdef"}, map[int][]string{
+		6: {"00000d|http://example.com/d", "00000c|http://example.com/c2"},
+	}}
+	dir := filepath.Join(root, p.release)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "isgd."+strings.TrimPrefix(p.release, "urlteam_")+".zip")
+	if err := os.WriteFile(name, makeTestZip(t, p), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idx.Update(nil); err != nil || n != 1 {
+		t.Fatalf("Update: got %d, %v, want 1 zip indexed", n, err)
+	}
+	entries, err := idx.Lookup("isgd", "00000c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if e := entries[1]; e.Shortcode != "00000c" || e.Target != "http://example.com/c2" {
+		t.Errorf("got entry %+v, want 00000c|http://example.com/c2", e)
+	}
+	link, err := entries[1].ReadLink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Source != "00000c" || link.Target != "http://example.com/c2" {
+		t.Errorf("got %v, want 00000c|http://example.com/c2", link)
+	}
+
+	// Segments in an older format are reindexed.
+	segment := idx.segmentFilename("isgd", name)
+	if err := os.WriteFile(segment, []byte("ttindex1"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idx.Update(nil); err != nil || n != 1 {
+		t.Fatalf("Update: got %d, %v, want 1 zip reindexed", n, err)
+	}
+
+	// A release with a sequence number follows the release with the
+	// same timestamp.
+	p = testProject{"urlteam_2016-01-21-20-17-02_1", Meta{Name: "isgd", Alphabet: "0123456789abcdef"}, map[int][]string{
+		6: {"00000c|http://example.com/c1"},
+	}}
+	dir = filepath.Join(root, p.release)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	name = filepath.Join(dir, "isgd.2016-01-21-20-17-02.1.zip")
+	if err := os.WriteFile(name, makeTestZip(t, p), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idx.Update(nil); err != nil || n != 1 {
+		t.Fatalf("Update: got %d, %v, want 1 zip indexed", n, err)
+	}
+	entries, err = idx.Lookup("isgd", "00000c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, e := range entries {
+		targets = append(targets, e.Target)
+	}
+	if want := []string{"http://example.com/c", "http://example.com/c1", "http://example.com/c2"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("got targets %q, want %q in release order", targets, want)
+	}
+}
+
+func TestIndexCorruptFooter(t *testing.T) {
+	root := writeTestReleases(t, testReleases[2:])
+	idx, err := OpenIndex(t.TempDir(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := filepath.Glob(filepath.Join(idx.dir, "isgd", "*.idx"))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("got segments %q, %v", segments, err)
+	}
+	b, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replace the dump count, after the release name, with a huge count.
+	footer := int(binary.BigEndian.Uint64(b[len(b)-8:]))
+	count := footer + 1 + int(b[footer])
+	corrupt := append(b[:count:count], 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f)
+	corrupt = append(corrupt, b[count+1:]...)
+	binary.BigEndian.PutUint64(corrupt[len(corrupt)-8:], uint64(footer))
+	if err := os.WriteFile(segments[0], corrupt, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Lookup("isgd", "00000c"); err == nil {
+		t.Error("Lookup: expected error for corrupt dump count")
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+// LinkIter iterates over the links in releases. Each call to Next
+// advances to the next link, which, with its project and location, is
+// accessed by the other methods until the following call. Iteration
+// stops when Next returns false, after which Err reports any error. An
+// iterator must be closed, unless Next has returned false.
+//
+//	it, err := tinytown.IterReleases(ctx, root, options)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		l := it.Link()
+//		...
+//	}
+//	return it.Err()
+//
+// The options apply as for ProcessReleases: DumpDone, Observer, and
+// Checkpoint are handled from the goroutine calling Next, and a link is
+// considered processed once Next is called again.
+type LinkIter struct {
+	link            *beacon.Link
+	meta            *Meta
+	shortcodeLen    int
+	releaseFilename string
+	dumpFilename    string
+
+	ctx     context.Context
+	options *ProcessOptions
+	links   linkSource
+	err     error
+	done    bool
+}
+
+// linkSource produces links for a LinkIter.
+type linkSource interface {
+	// next sets the current link of the iterator and reports whether
+	// there was one. At the end, it sets the error, if any.
+	next(it *LinkIter) bool
+	close()
+}
+
+// IterReleases returns an iterator over the links in every release in a
+// directory, like ProcessReleases. Iteration stops with the context's
+// error when it is cancelled.
+func IterReleases(ctx context.Context, root string, options *ProcessOptions) (*LinkIter, error) {
+	src := dirSource(root)
+	projects, err := src.findProjects(options)
+	if err != nil {
+		return nil, err
+	}
+	return newLinkIter(ctx, src, projects, options), nil
+}
+
+// IterReleasesFS returns an iterator over the links in every release in
+// a file system, like ProcessReleasesFS.
+func IterReleasesFS(ctx context.Context, fsys fs.FS, options *ProcessOptions) (*LinkIter, error) {
+	src := &releaseSource{fsys: fsys}
+	projects, err := src.findProjects(options)
+	if err != nil {
+		return nil, err
+	}
+	return newLinkIter(ctx, src, projects, options), nil
+}
+
+// IterProject returns an iterator over the links in a project release,
+// like ProcessProject.
+func IterProject(ctx context.Context, filename string, options *ProcessOptions) *LinkIter {
+	return newLinkIter(ctx, projectSource(filename), []string{filename}, options)
+}
+
+// IterProjectFS returns an iterator over the links in a project in a
+// file system, like ProcessProjectFS.
+func IterProjectFS(ctx context.Context, fsys fs.FS, name string, options *ProcessOptions) *LinkIter {
+	return newLinkIter(ctx, &releaseSource{fsys: fsys}, []string{name}, options)
+}
+
+func newLinkIter(ctx context.Context, src *releaseSource, projects []string, options *ProcessOptions) *LinkIter {
+	it := &LinkIter{ctx: ctx, options: options}
+	if options != nil && options.Workers > 1 {
+		it.links = newPoolLinks(ctx, src, projects, options)
+	} else {
+		it.links = &seqLinks{src: src, projects: projects, options: options}
+	}
+	return it
+}
+
+// processIter calls fn on every link of an iterator.
+func processIter(it *LinkIter, fn ProcessFunc) error {
+	defer it.Close()
+	for it.Next() {
+		if err := fn(it.link, it.meta, it.shortcodeLen, it.releaseFilename, it.dumpFilename); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// Next advances to the next link and reports whether there is one.
+func (it *LinkIter) Next() bool {
+	if it.done {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.stop(err)
+		return false
+	}
+	if it.links.next(it) {
+		return true
+	}
+	if it.err == nil {
+		it.err = it.options.checkpoint().Flush()
+	}
+	it.stop(it.err)
+	return false
+}
+
+func (it *LinkIter) stop(err error) {
+	it.err = err
+	it.done = true
+	it.link = nil
+	it.links.close()
+}
+
+// Link returns the current link.
+func (it *LinkIter) Link() *beacon.Link { return it.link }
+
+// Meta returns the metadata of the project of the current link.
+func (it *LinkIter) Meta() *Meta { return it.meta }
+
+// ShortcodeLen returns the shortcode length of the link dump of the
+// current link.
+func (it *LinkIter) ShortcodeLen() int { return it.shortcodeLen }
+
+// ReleaseFilename returns the filename of the project of the current
+// link.
+func (it *LinkIter) ReleaseFilename() string { return it.releaseFilename }
+
+// DumpFilename returns the name of the link dump of the current link.
+func (it *LinkIter) DumpFilename() string { return it.dumpFilename }
+
+// Err returns the error that stopped iteration, if any.
+func (it *LinkIter) Err() error { return it.err }
+
+// Close stops iteration and releases its resources.
+func (it *LinkIter) Close() error {
+	if !it.done {
+		it.done = true
+		it.link = nil
+		it.links.close()
+	}
+	return nil
+}
+
+// seqLinks reads link dumps sequentially from the goroutine calling
+// Next.
+type seqLinks struct {
+	src      *releaseSource
+	projects []string
+	options  *ProcessOptions
+
+	filename string
+	c        io.Closer // open project
+	meta     *Meta
+	dumps    []*projectFile // remaining dumps in project
+
+	dump   *projectFile
+	dr     *dumpReader // open dump
+	offset int64       // offset at which dr started
+	d      *DumpSummary
+	e      *progress.Event
+}
+
+func (s *seqLinks) next(it *LinkIter) bool {
+	for {
+		if s.dr != nil {
+			link, err := s.dr.Read()
+			if err == nil {
+				s.d.Links++
+				if err := s.options.checkpoint().progress(s.filename, s.dump.Name, s.offset+s.dr.Offset()); err != nil {
+					it.err = err
+					return false
+				}
+				it.link = link
+				return true
+			}
+			if err != io.EOF {
+				s.finishDump(err)
+				it.err = err
+				return false
+			}
+			s.d.Size = s.dr.cr.n
+			s.d.Validation = s.dr.validation()
+			if err := s.finishDump(nil); err != nil {
+				it.err = err
+				return false
+			}
+			continue
+		}
+		if len(s.dumps) != 0 {
+			if err := s.openDump(); err != nil {
+				it.err = err
+				return false
+			}
+			it.meta, it.shortcodeLen = s.meta, dumpShortcodeLen(s.dump.Name)
+			it.releaseFilename, it.dumpFilename = s.filename, s.dump.Name
+			continue
+		}
+		if s.c != nil {
+			s.c.Close()
+			s.c = nil
+		}
+		if len(s.projects) == 0 {
+			return false
+		}
+		s.filename, s.projects = s.projects[0], s.projects[1:]
+		c, meta, dumps, err := s.src.openProject(s.filename, s.options)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		s.c, s.meta, s.dumps = c, meta, dumps
+	}
+}
+
+func (s *seqLinks) openDump() error {
+	s.dump, s.dumps = s.dumps[0], s.dumps[1:]
+	s.e = &progress.Event{Kind: progress.Dump, Name: filepath.Base(s.filename) + ":" + s.dump.Name}
+	s.options.observer().Start(s.e)
+	s.d = &DumpSummary{
+		Meta:            s.meta,
+		ReleaseFilename: s.filename,
+		DumpFilename:    s.dump.Name,
+		CompressedSize:  s.dump.Size,
+	}
+	s.offset = s.options.checkpoint().offset(s.filename, s.dump.Name)
+	dr, err := openLinkDump(s.dump, s.offset)
+	if err != nil {
+		s.options.observer().Finish(s.e, err)
+		return err
+	}
+	if s.options != nil && s.options.Validate {
+		dr.validate(s.meta)
+	}
+	s.dr = dr
+	return nil
+}
+
+// finishDump closes the current dump and, when it was read without
+// error, records it as done.
+func (s *seqLinks) finishDump(err error) error {
+	s.dr.Close()
+	s.dr = nil
+	s.e.Links, s.e.Bytes = s.d.Links, s.d.Size
+	s.options.observer().Finish(s.e, err)
+	if err != nil {
+		return err
+	}
+	s.options.dumpDone(s.d)
+	return s.options.checkpoint().dumpDone(s.filename, s.dump.Name)
+}
+
+func (s *seqLinks) close() {
+	if s.dr != nil {
+		s.dr.Close()
+		s.dr = nil
+	}
+	if s.c != nil {
+		s.c.Close()
+		s.c = nil
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLinkIter(t *testing.T) {
+	projects := append([]testProject(nil), testReleases...)
+	var lines []string
+	for i := 0; i < 2*linkBatchSize+10; i++ {
+		lines = append(lines, fmt.Sprintf("%06x|http://example.com/%d", i, i))
+	}
+	projects = append(projects, testProject{"urlteam_2016-02-01-20-17-02",
+		Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{6: lines}})
+	root := writeTestReleases(t, projects)
+	want := collectSources(t, root, nil)
+
+	for _, workers := range []int{1, 4} {
+		options := &ProcessOptions{Workers: workers}
+		it, err := IterReleases(context.Background(), root, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for it.Next() {
+			if it.ShortcodeLen() != len(it.Link().Source) {
+				t.Errorf("workers=%d: shortcode length %d for %s in %s", workers, it.ShortcodeLen(), it.Link().Source, it.DumpFilename())
+			}
+			got = append(got, it.Meta().Name+":"+it.Link().Source)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("workers=%d: got %d links, want %d links in order", workers, len(got), len(want))
+		}
+
+		// Stop early
+		it, err = IterReleases(context.Background(), root, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3 && it.Next(); i++ {
+		}
+		if err := it.Close(); err != nil || it.Err() != nil || it.Next() {
+			t.Errorf("workers=%d: close: got error %v, iterator error %v", workers, err, it.Err())
+		}
+
+		// Cancel within the large dump
+		ctx, cancel := context.WithCancel(context.Background())
+		it, err = IterReleases(ctx, root, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for it.Next() {
+			if n++; n == len(want)-100 {
+				cancel()
+			}
+		}
+		if it.Err() != context.Canceled || n != len(want)-100 {
+			t.Errorf("workers=%d: cancel: got error %v after %d links, want %v after %d links", workers, it.Err(), n, context.Canceled, len(want)-100)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/shorteners"
+)
+
+// ProjectDefaults are the scan settings of a project that are probed
+// from the shortener, rather than derived from its definition.
+type ProjectDefaults struct {
+	RedirectCodes     []int   // HTTP codes for found shortcodes
+	NoRedirectCodes   []int   // HTTP codes for unused shortcodes
+	UnavailableCodes  []int   // HTTP codes for disabled shortcodes
+	BannedCodes       []int   // HTTP codes when rate limited
+	Method            string  // HTTP method, e.g., "head"
+	RequestDelay      float64 // seconds between requests, e.g., 0.5
+	BodyRegex         string  // extracts the URL from the body, when not redirecting
+	LocationAntiRegex string  // matches locations that are not found URLs
+}
+
+// DefaultProjectDefaults are the settings of a new terroroftinytown
+// project.
+var DefaultProjectDefaults = ProjectDefaults{
+	RedirectCodes:   []int{301, 302, 303, 307},
+	NoRedirectCodes: []int{404},
+	BannedCodes:     []int{403, 420, 429},
+	Method:          "head",
+	RequestDelay:    0.5,
+}
+
+// NewMeta constructs the settings of a terroroftinytown project for a
+// shortener. The project is disabled, so that it can be reviewed before
+// scanning. A nil defaults uses DefaultProjectDefaults.
+func NewMeta(s *shorteners.Shortener, defaults *ProjectDefaults) (*Meta, error) {
+	if s.Prefix == "" {
+		return nil, fmt.Errorf("tinytown: %s: shortener has no prefix", s.Name)
+	}
+	if s.Alphabet == "" {
+		return nil, fmt.Errorf("tinytown: %s: shortener has no alphabet", s.Name)
+	}
+	if defaults == nil {
+		defaults = &DefaultProjectDefaults
+	}
+	method := strings.ToLower(defaults.Method)
+	if method != "head" && method != "get" {
+		return nil, fmt.Errorf("tinytown: %s: unsupported method %q", s.Name, defaults.Method)
+	}
+	return &Meta{
+		Name:              s.Name,
+		Alphabet:          s.Alphabet,
+		URLTemplate:       s.Prefix + "{shortcode}",
+		RequestDelay:      defaults.RequestDelay,
+		RedirectCodes:     nonNilInts(defaults.RedirectCodes),
+		NoRedirectCodes:   nonNilInts(defaults.NoRedirectCodes),
+		UnavailableCodes:  nonNilInts(defaults.UnavailableCodes),
+		BannedCodes:       nonNilInts(defaults.BannedCodes),
+		BodyRegex:         defaults.BodyRegex,
+		LocationAntiRegex: defaults.LocationAntiRegex,
+		Method:            method,
+		NumCountPerItem:   50,
+		MaxNumItems:       100,
+		AutoreleaseTime:   30 * 60,
+	}, nil
+}
+
+// MarshalProjectConfig encodes the settings of a terroroftinytown
+// project for a shortener as JSON. The result is validated by decoding
+// it like the meta file of a release and deriving the shortener back.
+func MarshalProjectConfig(s *shorteners.Shortener, defaults *ProjectDefaults) ([]byte, error) {
+	m, err := NewMeta(s, defaults)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	m2, err := decodeMeta(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: %s: invalid project config: %w", s.Name, err)
+	}
+	s2, err := m2.Shortener()
+	if err != nil {
+		return nil, err
+	}
+	if s2.Host != s.Host || s2.Prefix != s.Prefix || s2.Alphabet != s.Alphabet {
+		return nil, fmt.Errorf("tinytown: %s: project config does not round trip: got host %s, prefix %s", s.Name, s2.Host, s2.Prefix)
+	}
+	return append(b, '\n'), nil
+}
+
+// nonNilInts returns an empty slice for nil, so that it is encoded as
+// an empty JSON array, rather than null.
+func nonNilInts(s []int) []int {
+	if s == nil {
+		return []int{}
+	}
+	return s
+}
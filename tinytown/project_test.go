@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/andrewarchi/urlhero/shorteners"
+)
+
+func TestMarshalProjectConfig(t *testing.T) {
+	b, err := MarshalProjectConfig(shorteners.Rbgy, &ProjectDefaults{
+		RedirectCodes:   []int{301},
+		NoRedirectCodes: []int{404},
+		Method:          "GET",
+		RequestDelay:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := decodeMeta(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Meta{
+		Name:             "rb-gy",
+		Alphabet:         shorteners.Rbgy.Alphabet,
+		URLTemplate:      "https://rb.gy/{shortcode}",
+		RequestDelay:     1,
+		RedirectCodes:    []int{301},
+		NoRedirectCodes:  []int{404},
+		UnavailableCodes: []int{},
+		BannedCodes:      []int{},
+		Method:           "get",
+		NumCountPerItem:  50,
+		MaxNumItems:      100,
+		AutoreleaseTime:  1800,
+	}
+	if !reflect.DeepEqual(*m, want) {
+		t.Errorf("got %+v, want %+v", *m, want)
+	}
+
+	if _, err := MarshalProjectConfig(&shorteners.Shortener{Name: "none", Prefix: "http://none.example/"}, nil); err == nil {
+		t.Error("expected error for shortener without alphabet")
+	}
+	if _, err := MarshalProjectConfig(shorteners.Rbgy, &ProjectDefaults{Method: "post"}); err == nil {
+		t.Error("expected error for unsupported method")
+	}
+}
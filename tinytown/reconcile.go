@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// DiscrepancyKind classifies a difference between the tracker and the
+// downloaded releases.
+type DiscrepancyKind uint8
+
+const (
+	// NoRelease is a project with results found by the tracker, but no
+	// downloaded release.
+	NoRelease DiscrepancyKind = iota
+	// Unlisted is a project with downloaded releases, which the tracker
+	// no longer lists.
+	Unlisted
+	// FoundMismatch is a project for which the number of results found
+	// by the tracker, less those pending export, differs from the number
+	// of links in its downloaded releases.
+	FoundMismatch
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case NoRelease:
+		return "no_release"
+	case Unlisted:
+		return "unlisted"
+	case FoundMismatch:
+		return "found_mismatch"
+	}
+	return fmt.Sprintf("DiscrepancyKind(%d)", uint8(k))
+}
+
+// Discrepancy is a difference between the tracker and the downloaded
+// releases for a project.
+type Discrepancy struct {
+	Project     string
+	Kind        DiscrepancyKind
+	Tracker     ProjectStats // zero when the tracker has no stats
+	Pending     int64        // results not yet exported
+	Links       int64        // links in downloaded releases
+	Releases    int          // downloaded releases
+	LastRelease time.Time    // zero when none were downloaded
+}
+
+// ReconcileTracker compares the projects of the tracker with the
+// projects in the releases under root that are selected by the options.
+// The results pending export, keyed by project ID as in
+// ReleaseStatus.ProjectResults, may be nil. Found counts are only
+// meaningful when every release of a project has been downloaded and
+// the options do not restrict the release times or shortcode lengths.
+// Discrepancies are sorted by project, then kind.
+func ReconcileTracker(root string, health *Health, pending map[string]int64, options *ProcessOptions) ([]Discrepancy, error) {
+	stats, err := GetStats(root, options)
+	if err != nil {
+		return nil, err
+	}
+	return reconcile(health, stats, pending, options), nil
+}
+
+func reconcile(health *Health, stats *Stats, pending map[string]int64, options *ProcessOptions) []Discrepancy {
+	listed := make(map[string]bool, len(health.Projects))
+	projects := make(map[string]struct{})
+	for _, p := range health.Projects {
+		listed[p] = true
+		projects[p] = struct{}{}
+	}
+	for p := range health.ProjectStats {
+		projects[p] = struct{}{}
+	}
+	for p := range stats.Projects {
+		projects[p] = struct{}{}
+	}
+	names := make([]string, 0, len(projects))
+	for p := range projects {
+		if options.matchProject(p) {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+
+	var discrepancies []Discrepancy
+	for _, p := range names {
+		ps, hasStats := health.ProjectStats[p]
+		d := Discrepancy{Project: p, Tracker: ps, Pending: pending[p]}
+		local, ok := stats.Projects[p]
+		if ok {
+			d.Links = local.Links
+			d.Releases = len(local.Releases)
+			d.LastRelease = local.LastRelease
+		}
+		if !ok {
+			if ps.Found-d.Pending > 0 {
+				d.Kind = NoRelease
+				discrepancies = append(discrepancies, d)
+			}
+			continue
+		}
+		if !listed[p] {
+			d.Kind = Unlisted
+			discrepancies = append(discrepancies, d)
+		}
+		if hasStats && d.Links != ps.Found-d.Pending {
+			d.Kind = FoundMismatch
+			discrepancies = append(discrepancies, d)
+		}
+	}
+	return discrepancies
+}
+
+// WriteDiscrepanciesCSV writes discrepancies as CSV with a header.
+func WriteDiscrepanciesCSV(w io.Writer, discrepancies []Discrepancy) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "kind", "tracker_found", "tracker_scanned", "pending", "links", "releases", "last_release"})
+	for _, d := range discrepancies {
+		last := ""
+		if !d.LastRelease.IsZero() {
+			last = d.LastRelease.Format(time.RFC3339)
+		}
+		row := append([]string{d.Project, d.Kind.String()},
+			formatInts(d.Tracker.Found, d.Tracker.Scanned, d.Pending, d.Links, int64(d.Releases))...)
+		cw.Write(append(row, last))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReconcileTracker(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	health := &Health{
+		Projects: []string{"bitly_6", "tinyurl", "goo-gl"},
+		ProjectStats: map[string]ProjectStats{
+			"bitly_6": {Found: 6, Scanned: 100},
+			"tinyurl": {Found: 3, Scanned: 50},
+			"goo-gl":  {Found: 0, Scanned: 10},
+			"isgd":    {Found: 1, Scanned: 20},
+		},
+	}
+	pending := map[string]int64{"bitly_6": 2}
+	discrepancies, err := ReconcileTracker(root, health, pending, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bitly_6 has 4 links and 6 found, of which 2 are pending, so it
+	// matches. isgd is unlisted, but its count matches.
+	var got []string
+	for _, d := range discrepancies {
+		got = append(got, fmt.Sprintf("%s %s %d/%d", d.Project, d.Kind, d.Links, d.Tracker.Found))
+	}
+	want := []string{"isgd unlisted 1/1", "tinyurl no_release 0/3"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	delete(pending, "bitly_6")
+	discrepancies, err = ReconcileTracker(root, health, pending, &ProcessOptions{Projects: []string{"bitly_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) != 1 || discrepancies[0].Kind != FoundMismatch || discrepancies[0].Releases != 2 {
+		t.Fatalf("got %+v", discrepancies)
+	}
+	var b bytes.Buffer
+	if err := WriteDiscrepanciesCSV(&b, discrepancies); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "project,kind,tracker_found,tracker_scanned,pending,links,releases,last_release\n" +
+		"bitly_6,found_mismatch,6,100,0,4,2,2016-01-21T20:17:02Z\n"
+	if b.String() != wantCSV {
+		t.Errorf("got CSV:\n%s", b.String())
+	}
+}
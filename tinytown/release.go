@@ -0,0 +1,188 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReleaseID identifies a release: either a terroroftinytown item, e.g.,
+// "urlteam_2016-01-21-20-17-02", which contains the zips of many
+// projects, or a project zip within an item, e.g.,
+// "bitly_6.2016-01-21-20-17-02.zip", or the directory it is extracted
+// into.
+type ReleaseID struct {
+	Prefix   string    // item identifier prefix, e.g., "urlteam"; empty for project zips
+	Project  string    // project name, e.g., "bitly_6"; empty for items
+	Time     time.Time // release timestamp, in UTC
+	Sequence int       // number distinguishing releases with the same timestamp, e.g., 1 for "urlteam_2016-01-21-20-17-02_1"; 0 when absent
+}
+
+const releaseTimeLayout = "2006-01-02-15-04-05"
+
+var (
+	itemIDPattern     = regexp.MustCompile(`^([a-z]+)_(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(?:_(\d+))?$`)
+	projectZipPattern = regexp.MustCompile(`^([^.]+)\.(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(?:\.(\d+))?(?:\.zip)?$`)
+)
+
+// ParseReleaseID parses an item identifier or project zip name. Any
+// directory in the name is ignored.
+func ParseReleaseID(name string) (ReleaseID, error) {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	var r ReleaseID
+	var ts, seq string
+	if m := itemIDPattern.FindStringSubmatch(base); m != nil {
+		r.Prefix, ts, seq = m[1], m[2], m[3]
+	} else if m := projectZipPattern.FindStringSubmatch(base); m != nil {
+		r.Project, ts, seq = m[1], m[2], m[3]
+	} else {
+		return ReleaseID{}, fmt.Errorf("tinytown: not a release identifier or project zip: %q", name)
+	}
+	t, err := time.Parse(releaseTimeLayout, ts)
+	if err != nil {
+		return ReleaseID{}, fmt.Errorf("tinytown: release %q: %w", name, err)
+	}
+	r.Time = t
+	if seq != "" {
+		if r.Sequence, err = strconv.Atoi(seq); err != nil {
+			return ReleaseID{}, fmt.Errorf("tinytown: release %q: %w", name, err)
+		}
+	}
+	return r, nil
+}
+
+// IsProject reports whether the identifier is of a project zip, rather
+// than an item.
+func (r ReleaseID) IsProject() bool {
+	return r.Project != ""
+}
+
+// String formats the identifier as an item identifier or a project zip
+// name.
+func (r ReleaseID) String() string {
+	ts := r.Time.UTC().Format(releaseTimeLayout)
+	if r.IsProject() {
+		if r.Sequence != 0 {
+			return fmt.Sprintf("%s.%s.%d.zip", r.Project, ts, r.Sequence)
+		}
+		return r.Project + "." + ts + ".zip"
+	}
+	if r.Sequence != 0 {
+		return fmt.Sprintf("%s_%s_%d", r.Prefix, ts, r.Sequence)
+	}
+	return r.Prefix + "_" + ts
+}
+
+// Before reports whether r was released before s: by time, then by
+// sequence number, then by project.
+func (r ReleaseID) Before(s ReleaseID) bool {
+	if !r.Time.Equal(s.Time) {
+		return r.Time.Before(s.Time)
+	}
+	if r.Sequence != s.Sequence {
+		return r.Sequence < s.Sequence
+	}
+	if r.Prefix != s.Prefix {
+		return r.Prefix < s.Prefix
+	}
+	return r.Project < s.Project
+}
+
+// SortReleases sorts item identifiers or project zip names
+// chronologically. Names that cannot be parsed are sorted by name
+// before the others.
+func SortReleases(names []string) {
+	ids := make([]ReleaseID, len(names))
+	ok := make([]bool, len(names))
+	for i, name := range names {
+		id, err := ParseReleaseID(name)
+		ids[i], ok[i] = id, err == nil
+	}
+	sort.Sort(releaseSorter{names, ids, ok})
+}
+
+type releaseSorter struct {
+	names []string
+	ids   []ReleaseID
+	ok    []bool
+}
+
+func (s releaseSorter) Len() int { return len(s.names) }
+
+func (s releaseSorter) Less(i, j int) bool {
+	if s.ok[i] != s.ok[j] {
+		return !s.ok[i]
+	}
+	if s.ok[i] && (s.ids[i].Before(s.ids[j]) || s.ids[j].Before(s.ids[i])) {
+		return s.ids[i].Before(s.ids[j])
+	}
+	return s.names[i] < s.names[j]
+}
+
+func (s releaseSorter) Swap(i, j int) {
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+	s.ok[i], s.ok[j] = s.ok[j], s.ok[i]
+}
+
+// projectRelease returns the identifier of the release of a project,
+// given its filename: the item of the release directory containing it
+// or, for a project at the root, as in a zip of an item, the project
+// itself.
+func projectRelease(filename string) (ReleaseID, bool) {
+	name := strings.ReplaceAll(filename, "\\", "/")
+	if id, err := ParseReleaseID(path.Base(path.Dir(name))); err == nil && !id.IsProject() {
+		return id, true
+	}
+	id, err := ParseReleaseID(path.Base(name))
+	return id, err == nil
+}
+
+// releaseLabel returns the name of the release of a project for
+// reports: the item identifier or, for a project at the root, the
+// timestamp of the project zip. Otherwise, it is the name of the
+// directory containing the project.
+func releaseLabel(filename string) string {
+	id, ok := projectRelease(filename)
+	if !ok {
+		name := strings.ReplaceAll(filename, "\\", "/")
+		if dir := path.Base(path.Dir(name)); dir != "." && dir != "/" {
+			return dir
+		}
+		return path.Base(name)
+	}
+	if !id.IsProject() {
+		return id.String()
+	}
+	label := id.Time.UTC().Format(releaseTimeLayout)
+	if id.Sequence != 0 {
+		label += "_" + strconv.Itoa(id.Sequence)
+	}
+	return label
+}
+
+// parseReleaseTime parses the timestamp in an item identifier or
+// project zip name.
+func parseReleaseTime(name string) (time.Time, bool) {
+	id, err := ParseReleaseID(name)
+	return id.Time, err == nil
+}
+
+// projectName returns the project of a project zip name, e.g.,
+// "bitly_6" for "bitly_6.2016-01-21-20-17-02.zip".
+func projectName(zipName string) string {
+	if id, err := ParseReleaseID(zipName); err == nil && id.IsProject() {
+		return id.Project
+	}
+	return trimAfterByte(path.Base(strings.ReplaceAll(zipName, "\\", "/")), '.')
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReleaseID(t *testing.T) {
+	ts := time.Date(2016, 1, 21, 20, 17, 2, 0, time.UTC)
+	tests := []struct {
+		name string
+		id   ReleaseID
+	}{
+		{"urlteam_2016-01-21-20-17-02", ReleaseID{Prefix: "urlteam", Time: ts}},
+		{"urlteam_2016-01-21-20-17-02_2", ReleaseID{Prefix: "urlteam", Time: ts, Sequence: 2}},
+		{"bitly_6.2016-01-21-20-17-02.zip", ReleaseID{Project: "bitly_6", Time: ts}},
+		{"/releases/urlteam_2016-01-21-20-17-02/isgd.2016-01-21-20-17-02.1.zip", ReleaseID{Project: "isgd", Time: ts, Sequence: 1}},
+	}
+	for _, tt := range tests {
+		id, err := ParseReleaseID(tt.name)
+		if err != nil {
+			t.Errorf("ParseReleaseID(%q): %v", tt.name, err)
+			continue
+		}
+		if id != tt.id {
+			t.Errorf("ParseReleaseID(%q) = %+v, want %+v", tt.name, id, tt.id)
+		}
+		if s, err := ParseReleaseID(id.String()); err != nil || s != id {
+			t.Errorf("round trip of %q: got %+v, %v", id.String(), s, err)
+		}
+	}
+	for _, name := range []string{"urlteam", "bitly_6.zip", "urlteam_2016-13-21-20-17-02"} {
+		if id, err := ParseReleaseID(name); err == nil {
+			t.Errorf("ParseReleaseID(%q) = %+v, want error", name, id)
+		}
+	}
+}
+
+func TestSortReleases(t *testing.T) {
+	names := []string{
+		"urlteam_2016-01-21-20-17-02_1",
+		"terroroftinytown_2016-01-01-00-00-00",
+		"notes",
+		"urlteam_2015-12-31-20-17-02",
+		"urlteam_2016-01-21-20-17-02",
+	}
+	want := []string{
+		"notes",
+		"urlteam_2015-12-31-20-17-02",
+		"terroroftinytown_2016-01-01-00-00-00",
+		"urlteam_2016-01-21-20-17-02",
+		"urlteam_2016-01-21-20-17-02_1",
+	}
+	SortReleases(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestReleaseLabel(t *testing.T) {
+	tests := []struct{ filename, label string }{
+		{"/data/urlteam_2016-01-21-20-17-02/bitly_6.2016-01-21-20-17-02.zip", "urlteam_2016-01-21-20-17-02"},
+		{"urlteam_2016-01-21-20-17-02_1/bitly_6.2016-01-21-20-17-02.1.zip", "urlteam_2016-01-21-20-17-02_1"},
+		{"bitly_6.2016-01-21-20-17-02.zip", "2016-01-21-20-17-02"},
+		{"/data/bitly_6.2016-01-21-20-17-02.1.zip", "2016-01-21-20-17-02_1"},
+		{"misc/bitly_6.zip", "misc"},
+		{"bitly_6.zip", "bitly_6.zip"},
+	}
+	for _, tt := range tests {
+		if label := releaseLabel(tt.filename); label != tt.label {
+			t.Errorf("releaseLabel(%q) = %q, want %q", tt.filename, label, tt.label)
+		}
+	}
+}
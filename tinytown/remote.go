@@ -0,0 +1,182 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPFile is a file served over HTTP that is read at offsets with
+// Range requests. Reads are rounded up to a block, so that the small
+// reads of zip and xz decoding do not each make a request. A block is
+// cached for each sequential reader, so that concurrent workers
+// decoding different link dumps do not evict each other's blocks.
+type HTTPFile struct {
+	url       string
+	size      int64
+	blockSize int64
+	maxBlocks int
+
+	mu     sync.Mutex  // guards blocks
+	blocks []httpBlock // cached blocks, least recently used first
+}
+
+type httpBlock struct {
+	off int64
+	b   []byte
+}
+
+func (b httpBlock) end() int64 {
+	return b.off + int64(len(b.b))
+}
+
+var (
+	// httpFileBlockSize is the minimum size of Range requests.
+	httpFileBlockSize int64 = 1 << 20
+	// httpFileMaxBlocks is the maximum number of blocks cached, which
+	// bounds the number of concurrent readers that are not thrashed.
+	httpFileMaxBlocks = 16
+)
+
+// OpenHTTPFile gets the size of a file served over HTTP and checks that
+// the server supports Range requests.
+func OpenHTTPFile(url string) (*HTTPFile, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tinytown: http status %s", resp.Status)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, fmt.Errorf("tinytown: range requests not supported: %s", url)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("tinytown: unknown content length: %s", url)
+	}
+	return &HTTPFile{url: url, size: resp.ContentLength, blockSize: httpFileBlockSize, maxBlocks: httpFileMaxBlocks}, nil
+}
+
+// Size returns the size of the file.
+func (f *HTTPFile) Size() int64 {
+	return f.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *HTTPFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("tinytown: negative offset: %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		m, err := f.readBlock(p[n:], off)
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readBlock reads from the cached block containing off, fetching it
+// when not cached. The request is made without holding the lock, so
+// that readers at different offsets fetch concurrently.
+func (f *HTTPFile) readBlock(p []byte, off int64) (int, error) {
+	if b, ok := f.cached(off); ok {
+		return copy(p, b.b[off-b.off:]), nil
+	}
+	size := f.blockSize
+	if int64(len(p)) > size {
+		size = int64(len(p))
+	}
+	if off+size > f.size {
+		size = f.size - off
+	}
+	data, err := f.fetch(off, size)
+	if err != nil {
+		return 0, err
+	}
+	b := httpBlock{off, data}
+	f.insert(b)
+	return copy(p, b.b), nil
+}
+
+// cached returns the cached block containing off and marks it as most
+// recently used.
+func (f *HTTPFile) cached(off int64) (httpBlock, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, b := range f.blocks {
+		if b.off <= off && off < b.end() {
+			f.blocks = append(append(f.blocks[:i:i], f.blocks[i+1:]...), b)
+			return b, true
+		}
+	}
+	return httpBlock{}, false
+}
+
+// insert caches a block. A block that ends where the new block starts
+// belongs to the same sequential reader and is replaced; otherwise, the
+// least recently used block is evicted when the cache is full.
+func (f *HTTPFile) insert(b httpBlock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, c := range f.blocks {
+		if c.end() == b.off {
+			f.blocks = append(f.blocks[:i], f.blocks[i+1:]...)
+			break
+		}
+	}
+	if len(f.blocks) >= f.maxBlocks {
+		f.blocks = f.blocks[len(f.blocks)-f.maxBlocks+1:]
+	}
+	f.blocks = append(f.blocks, b)
+}
+
+func (f *HTTPFile) fetch(off, size int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+size-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("tinytown: range request: http status %s", resp.Status)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close implements io.Closer. It releases the cached blocks.
+func (f *HTTPFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks = nil
+	return nil
+}
+
+// IsURL reports whether a project filename is an HTTP URL, which is
+// read remotely by ProcessProject.
+func IsURL(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
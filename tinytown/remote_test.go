@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+func TestProcessRemoteProject(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("%06x|http://example.com/%x", i, rng.Uint64()))
+	}
+	p := testProject{"urlteam_2016-01-21-20-17-02", Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{
+		5: {"0000a|http://example.com/5"},
+		6: lines,
+	}}
+	root := writeTestReleases(t, []testProject{p})
+	zipName := "bitly_6.2016-01-21-20-17-02.zip"
+	fi, err := os.Stat(filepath.Join(root, p.release, zipName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var served int64
+	fs := http.FileServer(http.Dir(root))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.ServeHTTP(countingResponseWriter{w, &served}, r)
+	}))
+	defer ts.Close()
+	url := ts.URL + "/" + p.release + "/" + zipName
+	defer func(size int64) { httpFileBlockSize = size }(httpFileBlockSize)
+	httpFileBlockSize = 1024
+
+	var links []string
+	err = ProcessProject(url, &ProcessOptions{ShortcodeLens: []int{5}}, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		links = append(links, l.Source+"|"+l.Target)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0000a|http://example.com/5"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("got %q, want %q", links, want)
+	}
+	if served >= fi.Size() {
+		t.Errorf("served %d bytes of %d byte zip, want only directory and selected dump", served, fi.Size())
+	}
+
+	hf, err := OpenHTTPFile(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hf.blockSize = 7
+	b := make([]byte, 100)
+	if _, err := hf.ReadAt(b, fi.Size()-100); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join(root, p.release, zipName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(want[len(want)-100:]) {
+		t.Error("ReadAt: data differs from file")
+	}
+	if n, err := hf.ReadAt(b, fi.Size()-10); n != 10 || err == nil {
+		t.Errorf("ReadAt past end: got %d, %v, want 10, EOF", n, err)
+	}
+
+	// Interleaved sequential readers each keep their own block cached.
+	var requests int64
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&requests, 1)
+		}
+		fs.ServeHTTP(w, r)
+	}))
+	defer ts2.Close()
+	hf, err = OpenHTTPFile(ts2.URL + "/" + p.release + "/" + zipName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hf.blockSize = 64
+	b = make([]byte, 8)
+	for off := int64(0); off < 256; off += int64(len(b)) {
+		for _, base := range []int64{0, 1024, 2048} {
+			if _, err := hf.ReadAt(b, base+off); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != string(want[base+off:base+off+int64(len(b))]) {
+				t.Fatalf("ReadAt(%d): data differs from file", base+off)
+			}
+		}
+	}
+	if got, want := atomic.LoadInt64(&requests), int64(3*256/64); got != want {
+		t.Errorf("interleaved readers made %d requests, want %d", got, want)
+	}
+	if len(hf.blocks) != 3 {
+		t.Errorf("cached %d blocks, want 3", len(hf.blocks))
+	}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
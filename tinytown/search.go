@@ -7,54 +7,73 @@
 package tinytown
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
+	"net/url"
 	"strings"
 
 	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/shorteners"
 )
 
-func SearchReleases(root, shortener string, shortcodes []string) ([]*beacon.Link, error) {
-	// TODO merge with ProcessReleases.
+// SearchResult is a link found in a release.
+type SearchResult struct {
+	Link            *beacon.Link
+	Project         string
+	ReleaseFilename string
+	DumpFilename    string
+}
+
+// SearchReleases searches the releases of a project for links with the
+// given shortcodes. Full short URLs are cleaned to their shortcodes.
+// Only link dumps with the lengths of the shortcodes are read.
+func SearchReleases(root, project string, shortcodes []string) ([]SearchResult, error) {
 	shortcodeMap := make(map[string]struct{})
+	lens := make(map[int]struct{})
+	options := &ProcessOptions{Projects: []string{project}}
 	for _, shortcode := range shortcodes {
+		shortcode, err := cleanShortcode(project, shortcode)
+		if err != nil {
+			return nil, err
+		}
+		if shortcode == "" {
+			continue
+		}
 		shortcodeMap[shortcode] = struct{}{}
+		if _, ok := lens[len(shortcode)]; !ok {
+			lens[len(shortcode)] = struct{}{}
+			options.ShortcodeLens = append(options.ShortcodeLens, len(shortcode))
+		}
 	}
+	if len(shortcodeMap) == 0 {
+		return nil, nil
+	}
+
+	var results []SearchResult
+	err := ProcessReleases(root, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		if _, ok := shortcodeMap[l.Source]; ok {
+			results = append(results, SearchResult{l, m.Name, releaseFilename, dumpFilename})
+		}
+		return nil
+	})
+	return results, err
+}
 
-	rootContents, err := os.ReadDir(root)
+// cleanShortcode extracts the shortcode from a short URL using the
+// shortener for its host. Strings that are not URLs are returned as-is.
+func cleanShortcode(project, shortcode string) (string, error) {
+	if !strings.Contains(shortcode, "/") {
+		return shortcode, nil
+	}
+	if !strings.Contains(shortcode, "://") {
+		shortcode = "http://" + shortcode
+	}
+	u, err := url.Parse(shortcode)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	var links []*beacon.Link
-	for _, release := range rootContents {
-		if !release.IsDir() {
-			continue
-		}
-		dir := filepath.Join(root, release.Name())
-		dirContents, err := os.ReadDir(dir)
-		if err != nil {
-			return nil, err
-		}
-		for _, file := range dirContents {
-			name := file.Name()
-			if !strings.HasPrefix(name, shortener+".") || !strings.HasSuffix(name, ".zip") {
-				continue
-			}
-			filename := filepath.Join(dir, name)
-			// TODO only search link dumps with shortcode length in the set of
-			// lengths being searched for.
-			fn := func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
-				if _, ok := shortcodeMap[l.Source]; ok {
-					fmt.Printf("%s|%q\n", l.Source, l.Target)
-					links = append(links, l)
-				}
-				return nil
-			}
-			if err := ProcessProject(filename, fn); err != nil {
-				return nil, err
-			}
-		}
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	s, ok := shorteners.Lookup[host]
+	if !ok {
+		s = &shorteners.Shortener{Name: project, Host: host}
 	}
-	return links, nil
+	return s.CleanURL(u)
 }
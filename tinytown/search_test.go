@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import "testing"
+
+func TestSearchReleases(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	results, err := SearchReleases(root, "bitly_6", []string{"00000a", "https://bit.ly/00000b?x=1", "zzzzzzz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://example.com/2015", "http://example.com/a", "http://example.com/b"}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Project != "bitly_6" || r.Link.Target != want[i] {
+			t.Errorf("#%d: got %s %v, want bitly_6 %s", i, r.Project, r.Link, want[i])
+		}
+	}
+}
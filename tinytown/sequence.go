@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Projects scan shortcodes by sequence number, which is converted to a
+// shortcode as a number in the base of the alphabet, without leading
+// zeros (the first character of the alphabet). A shortcode with leading
+// zeros has the same sequence number as without them.
+
+// SequenceNum converts a shortcode to its sequence number in the
+// project alphabet.
+func (m *Meta) SequenceNum(shortcode string) (int64, error) {
+	return sequenceNum(m.Alphabet, shortcode)
+}
+
+// Shortcode converts a sequence number to its shortcode in the project
+// alphabet.
+func (m *Meta) Shortcode(seq int64) (string, error) {
+	return shortcodeOf(m.Alphabet, seq)
+}
+
+func sequenceNum(alphabet, shortcode string) (int64, error) {
+	if len(alphabet) < 2 {
+		return 0, fmt.Errorf("tinytown: alphabet too small: %q", alphabet)
+	}
+	if shortcode == "" {
+		return 0, fmt.Errorf("tinytown: empty shortcode")
+	}
+	base := int64(len(alphabet))
+	var seq int64
+	for i := 0; i < len(shortcode); i++ {
+		digit := strings.IndexByte(alphabet, shortcode[i])
+		if digit == -1 {
+			return 0, fmt.Errorf("tinytown: shortcode %q not in alphabet %q", shortcode, alphabet)
+		}
+		if seq > (math.MaxInt64-int64(digit))/base {
+			return 0, fmt.Errorf("tinytown: shortcode %q overflows sequence number", shortcode)
+		}
+		seq = seq*base + int64(digit)
+	}
+	return seq, nil
+}
+
+func shortcodeOf(alphabet string, seq int64) (string, error) {
+	if len(alphabet) < 2 {
+		return "", fmt.Errorf("tinytown: alphabet too small: %q", alphabet)
+	}
+	if seq < 0 {
+		return "", fmt.Errorf("tinytown: negative sequence number: %d", seq)
+	}
+	if seq == 0 {
+		return alphabet[:1], nil
+	}
+	base := int64(len(alphabet))
+	var b [64]byte
+	i := len(b)
+	for seq != 0 {
+		i--
+		b[i] = alphabet[seq%base]
+		seq /= base
+	}
+	return string(b[i:]), nil
+}
+
+// sequenceRange returns the inclusive range of sequence numbers with
+// shortcodes of length n. It reports false when the range overflows.
+func sequenceRange(alphabet string, n int) (SeqRange, bool) {
+	base := int64(len(alphabet))
+	if n < 1 || base < 2 {
+		return SeqRange{}, false
+	}
+	if n == 1 {
+		return SeqRange{0, base - 1}, true
+	}
+	lower := int64(1)
+	for i := 1; i < n; i++ {
+		if lower > math.MaxInt64/base {
+			return SeqRange{}, false
+		}
+		lower *= base
+	}
+	upper := int64(math.MaxInt64)
+	if lower <= math.MaxInt64/base {
+		upper = lower*base - 1
+	}
+	return SeqRange{lower, upper}, true
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSequenceNum(t *testing.T) {
+	m := &Meta{Alphabet: "0123456789abcdef"}
+	tests := []struct {
+		shortcode string
+		seq       int64
+	}{
+		{"0", 0},
+		{"f", 15},
+		{"10", 16},
+		{"ff", 255},
+		{"100", 256},
+		{"7fffffffffffffff", 1<<63 - 1},
+	}
+	for _, tt := range tests {
+		seq, err := m.SequenceNum(tt.shortcode)
+		if err != nil || seq != tt.seq {
+			t.Errorf("SequenceNum(%q) = %d, %v, want %d", tt.shortcode, seq, err, tt.seq)
+		}
+		shortcode, err := m.Shortcode(tt.seq)
+		if err != nil || shortcode != tt.shortcode {
+			t.Errorf("Shortcode(%d) = %q, %v, want %q", tt.seq, shortcode, err, tt.shortcode)
+		}
+	}
+	for _, shortcode := range []string{"", "0g", "8000000000000000"} {
+		if _, err := m.SequenceNum(shortcode); err == nil {
+			t.Errorf("SequenceNum(%q): expected error", shortcode)
+		}
+	}
+	if r, ok := sequenceRange(m.Alphabet, 2); !ok || r != (SeqRange{16, 255}) {
+		t.Errorf("sequenceRange(2) = %v, %t, want {16 255}", r, ok)
+	}
+}
+
+func TestScanCoverage(t *testing.T) {
+	var lines []string
+	for _, s := range []string{"100", "101", "103", "1f0", "1f1", "1f1", "xyz"} {
+		lines = append(lines, s+"|http://example.com/"+s)
+	}
+	projects := []testProject{
+		{"urlteam_2016-01-21-20-17-02", Meta{Name: "cov", Alphabet: "0123456789abcdef"}, map[int][]string{3: lines}},
+		// Out of order and overlapping the ranges of the first release
+		{"urlteam_2016-02-01-20-17-02", Meta{Name: "cov", Alphabet: "0123456789abcdef"}, map[int][]string{3: {
+			"104|http://example.com/104",
+			"102|http://example.com/102",
+			"1f3|http://example.com/1f3",
+			"fff|http://example.com/fff",
+		}}},
+	}
+	root := writeTestReleases(t, projects)
+	coverage, err := ScanCoverage(root, nil, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Coverage{{
+		Project:  "cov",
+		Alphabet: "0123456789abcdef",
+		Lengths: []LengthCoverage{{
+			ShortcodeLen: 3,
+			Space:        SeqRange{256, 4095},
+			Found:        9,
+			Invalid:      1,
+			Ranges:       []SeqRange{{256, 260}, {496, 499}, {4095, 4095}},
+			Gaps:         []SeqRange{{261, 495}, {500, 4094}},
+			Density:      9.0 / 10,
+		}},
+	}}
+	if !reflect.DeepEqual(coverage, want) {
+		t.Errorf("got %+v, want %+v", coverage, want)
+	}
+}
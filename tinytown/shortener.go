@@ -0,0 +1,115 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/shorteners"
+)
+
+// Shortener derives a shortener from the settings of a project. The
+// prefix is the URL template up to the {shortcode} placeholder and
+// shortcodes are restricted to the project alphabet.
+func (m *Meta) Shortener() (*shorteners.Shortener, error) {
+	i := strings.Index(m.URLTemplate, "{shortcode}")
+	if i == -1 {
+		return nil, fmt.Errorf("tinytown: %s: URL template has no shortcode: %q", m.Name, m.URLTemplate)
+	}
+	prefix, suffix := m.URLTemplate[:i], m.URLTemplate[i+len("{shortcode}"):]
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: %s: %w", m.Name, err)
+	}
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	if host == "" {
+		return nil, fmt.Errorf("tinytown: %s: URL template has no host: %q", m.Name, m.URLTemplate)
+	}
+	s := &shorteners.Shortener{
+		Name:     m.Name,
+		Host:     host,
+		Prefix:   prefix,
+		Alphabet: m.Alphabet,
+	}
+	if m.Alphabet != "" {
+		s.Pattern, err = alphabetPattern(m.Alphabet)
+		if err != nil {
+			return nil, fmt.Errorf("tinytown: %s: %w", m.Name, err)
+		}
+	}
+	if suffix != "" {
+		// Remove template suffix, such as a redirect preview
+		s.CleanFunc = func(shortcode string, u *url.URL) string {
+			return strings.TrimSuffix(shortcode, suffix)
+		}
+	}
+	return s, nil
+}
+
+// alphabetPattern compiles a pattern that matches strings of one or
+// more characters in the alphabet.
+func alphabetPattern(alphabet string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^[")
+	for _, r := range alphabet {
+		if strings.ContainsRune(`\-[]^`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString("]+$")
+	return regexp.Compile(b.String())
+}
+
+// RegisterProjects registers a shortener for every project in the
+// releases selected by the options, using the settings from the latest
+// release of each project. Projects with the name of an already
+// registered shortener are not registered again. The shortener of every
+// project is returned, whether newly or already registered, sorted by
+// name.
+func RegisterProjects(root string, options *ProcessOptions) ([]*shorteners.Shortener, error) {
+	src := dirSource(root)
+	projects, err := src.findProjects(options)
+	if err != nil {
+		return nil, err
+	}
+	metas := make(map[string]*Meta)
+	for _, filename := range projects {
+		c, meta, _, err := src.openProject(filename, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.Close()
+		metas[meta.Name] = meta
+	}
+	names := make([]string, 0, len(metas))
+	for name := range metas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var registered []*shorteners.Shortener
+	for _, name := range names {
+		if s, ok := shorteners.Lookup[name]; ok {
+			registered = append(registered, s)
+			continue
+		}
+		s, err := metas[name].Shortener()
+		if err != nil {
+			return registered, err
+		}
+		if err := shorteners.Register(s); err != nil {
+			return registered, err
+		}
+		registered = append(registered, s)
+	}
+	return registered, nil
+}
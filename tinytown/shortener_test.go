@@ -0,0 +1,92 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"testing"
+
+	"github.com/andrewarchi/urlhero/shorteners"
+)
+
+func TestMetaShortener(t *testing.T) {
+	m := &Meta{Name: "test-ly", Alphabet: "0123456789abc-", URLTemplate: "http://www.test.ly/{shortcode}+"}
+	s, err := m.Shortener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "test-ly" || s.Host != "test.ly" || s.Prefix != "http://www.test.ly/" || s.Alphabet != m.Alphabet {
+		t.Errorf("got %+v", s)
+	}
+	tests := []struct{ url, shortcode string }{
+		{"http://test.ly/0a-b", "0a-b"},
+		{"https://www.test.ly/0a+", "0a"},
+		{"http://test.ly/robots.txt", ""},
+	}
+	for _, tt := range tests {
+		shortcode, err := s.Clean(tt.url)
+		if err != nil {
+			t.Errorf("Clean(%q): %v", tt.url, err)
+		} else if shortcode != tt.shortcode {
+			t.Errorf("Clean(%q) = %q, want %q", tt.url, shortcode, tt.shortcode)
+		}
+	}
+	if _, err := s.Clean("http://test.ly/0aZ"); err == nil {
+		t.Error("Clean: expected error for shortcode outside alphabet")
+	}
+
+	if _, err := (&Meta{Name: "bad", URLTemplate: "http://bad.example/"}).Shortener(); err == nil {
+		t.Error("expected error for template without shortcode")
+	}
+}
+
+func TestRegisterProjects(t *testing.T) {
+	projects := []testProject{
+		{"urlteam_2016-01-21-20-17-02", Meta{Name: "reg_6", Alphabet: "0123456789abcdef", URLTemplate: "http://reg.example/{shortcode}"}, nil},
+		{"urlteam_2016-02-01-20-17-02", Meta{Name: "reg_6", Alphabet: "0123456789abcdefg", URLTemplate: "http://reg.example/{shortcode}"}, nil},
+		{"urlteam_2016-02-01-20-17-02", Meta{Name: "reg_7", Alphabet: "0123456789", URLTemplate: "http://reg.example/{shortcode}"}, nil},
+	}
+	root := writeTestReleases(t, projects)
+	resetShorteners(t)
+	registered, err := RegisterProjects(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) != 2 || registered[0].Name != "reg_6" || registered[1].Name != "reg_7" {
+		t.Fatalf("got %v", registered)
+	}
+	if s := shorteners.Lookup["reg_6"]; s == nil || s.Alphabet != "0123456789abcdefg" {
+		t.Errorf("reg_6: got %+v, want settings from latest release", s)
+	}
+	if s := shorteners.Lookup["reg.example"]; s == nil || s.Name != "reg_6" {
+		t.Errorf("reg.example: got %+v, want reg_6", s)
+	}
+	n := len(shorteners.Shorteners)
+	reregistered, err := RegisterProjects(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reregistered) != 2 || reregistered[0] != registered[0] || reregistered[1] != registered[1] {
+		t.Errorf("re-register: got %v, want %v", reregistered, registered)
+	}
+	if len(shorteners.Shorteners) != n {
+		t.Errorf("re-register: registered %d more shorteners", len(shorteners.Shorteners)-n)
+	}
+}
+
+// resetShorteners restores the registered shorteners at the end of the
+// test.
+func resetShorteners(t *testing.T) {
+	all := shorteners.Shorteners
+	lookup := make(map[string]*shorteners.Shortener, len(shorteners.Lookup))
+	for k, s := range shorteners.Lookup {
+		lookup[k] = s
+	}
+	t.Cleanup(func() {
+		shorteners.Shorteners = all[:len(all):len(all)]
+		shorteners.Lookup = lookup
+	})
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ProcessReleasesFS processes every release in a file system by calling
+// fn on every link. Like the directory of ProcessReleases, the root
+// contains release directories of project zips, but the root may also
+// contain project zips directly, as in a zip of an item, and projects
+// may be extracted into directories of *.meta.json.xz and *.txt.xz
+// files. Release filenames passed to fn are slash-separated paths in
+// fsys.
+func ProcessReleasesFS(fsys fs.FS, options *ProcessOptions, fn ProcessFunc) error {
+	it, err := IterReleasesFS(context.Background(), fsys, options)
+	if err != nil {
+		return err
+	}
+	return processIter(it, fn)
+}
+
+// ProcessProjectFS processes every link dump in a project zip or
+// extracted project directory in a file system by calling fn on every
+// link.
+func ProcessProjectFS(fsys fs.FS, name string, options *ProcessOptions, fn ProcessFunc) error {
+	return processIter(IterProjectFS(context.Background(), fsys, name, options), fn)
+}
+
+// releaseSource is a file system of releases. Projects are identified
+// by filename, which for a local directory is the path on disk, so that
+// filenames passed to ProcessFunc can be opened directly.
+type releaseSource struct {
+	fsys fs.FS
+	root string // directory of fsys on disk; empty for other file systems
+}
+
+// dirSource returns a source for the releases in a local directory.
+func dirSource(root string) *releaseSource {
+	return &releaseSource{fsys: os.DirFS(root), root: root}
+}
+
+// projectSource returns a source for a single project, which may be a
+// local path, a URL, or a path in fsys.
+func projectSource(filename string) *releaseSource {
+	if IsURL(filename) {
+		return &releaseSource{}
+	}
+	return dirSource(filepath.Dir(filename))
+}
+
+func (s *releaseSource) filename(name string) string {
+	if s.root == "" {
+		return name
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+func (s *releaseSource) name(filename string) (string, error) {
+	if s.root == "" {
+		return filename, nil
+	}
+	rel, err := filepath.Rel(s.root, filename)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// findProjects lists the filenames of the projects that are selected by
+// the options. Projects are zips or extracted directories in the
+// release directories or the root.
+func (s *releaseSource) findProjects(options *ProcessOptions) ([]string, error) {
+	rootContents, err := fs.ReadDir(s.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	// Releases are processed chronologically, even when their names
+	// have different prefixes.
+	entries := make(map[string]fs.DirEntry, len(rootContents))
+	names := make([]string, len(rootContents))
+	for i, entry := range rootContents {
+		entries[entry.Name()] = entry
+		names[i] = entry.Name()
+	}
+	SortReleases(names)
+	var projects []string
+	add := func(name string) {
+		base := path.Base(name)
+		if options.matchTime(base) && options.matchProject(projectName(base)) {
+			projects = append(projects, s.filename(name))
+		}
+	}
+	for _, name := range names {
+		entry := entries[name]
+		if !entry.IsDir() {
+			if strings.HasSuffix(name, ".zip") {
+				add(name)
+			}
+			continue
+		}
+		if !options.matchTime(name) {
+			continue
+		}
+		extracted, err := s.isExtracted(name)
+		if err != nil {
+			return nil, err
+		}
+		if extracted {
+			add(name)
+			continue
+		}
+		dirContents, err := fs.ReadDir(s.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range dirContents {
+			fname := path.Join(name, file.Name())
+			if file.IsDir() {
+				if extracted, err := s.isExtracted(fname); err != nil {
+					return nil, err
+				} else if !extracted {
+					continue
+				}
+			} else if !strings.HasSuffix(fname, ".zip") {
+				continue
+			}
+			add(fname)
+		}
+	}
+	return projects, nil
+}
+
+// isExtracted reports whether a directory is an extracted project,
+// containing a meta file.
+func (s *releaseSource) isExtracted(dir string) (bool, error) {
+	matches, err := fs.Glob(s.fsys, path.Join(dir, "*.meta.json.xz"))
+	return len(matches) != 0, err
+}
+
+// projectFile is a file in a project: a member of a project zip or a
+// file in an extracted project directory.
+type projectFile struct {
+	Name string // base name, e.g., "xxxxxx.txt.xz"
+	Size int64  // bytes of the xz-compressed file
+	open func() (io.ReadCloser, error)
+}
+
+// Open opens the xz-compressed file.
+func (f *projectFile) Open() (io.ReadCloser, error) {
+	return f.open()
+}
+
+func zipProjectFiles(files []*zip.File) []*projectFile {
+	pfs := make([]*projectFile, len(files))
+	for i, f := range files {
+		pfs[i] = &projectFile{Name: f.Name, Size: int64(f.UncompressedSize64), open: f.Open}
+	}
+	return pfs
+}
+
+// openProject opens a project, which may be a zip, an extracted
+// directory, or the URL of a zip, and reads its meta. The link dumps
+// selected by the options are returned.
+func (s *releaseSource) openProject(filename string, options *ProcessOptions) (io.Closer, *Meta, []*projectFile, error) {
+	c, files, err := s.openProjectFiles(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	metaFile, dumps, err := classifyFiles(files, filename)
+	if err != nil {
+		c.Close()
+		return nil, nil, nil, err
+	}
+	meta, err := readMeta(metaFile)
+	if err != nil {
+		c.Close()
+		return nil, nil, nil, err
+	}
+	selected := dumps[:0:0]
+	for _, f := range dumps {
+		if options.matchDump(filename, f.Name) {
+			selected = append(selected, f)
+		}
+	}
+	return c, meta, selected, nil
+}
+
+func (s *releaseSource) openProjectFiles(filename string) (io.Closer, []*projectFile, error) {
+	if IsURL(filename) {
+		hf, err := OpenHTTPFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		zr, err := zip.NewReader(hf, hf.Size())
+		if err != nil {
+			return nil, nil, err
+		}
+		return hf, zipProjectFiles(zr.File), nil
+	}
+	if s.root != "" && strings.HasSuffix(filename, ".zip") {
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zipProjectFiles(zr.File), nil
+	}
+
+	name, err := s.name(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		files, err := s.extractedFiles(name)
+		return io.NopCloser(nil), files, err
+	}
+	// Zips within other file systems, such as zip-in-zip, are read into
+	// memory when they cannot be read at offsets.
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		ra, f = bytes.NewReader(b), nil
+	}
+	zr, err := zip.NewReader(ra, fi.Size())
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, nil, err
+	}
+	if f == nil {
+		return io.NopCloser(nil), zipProjectFiles(zr.File), nil
+	}
+	return f, zipProjectFiles(zr.File), nil
+}
+
+func (s *releaseSource) extractedFiles(dir string) ([]*projectFile, error) {
+	entries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*projectFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		name := path.Join(dir, e.Name())
+		files = append(files, &projectFile{
+			Name: e.Name(),
+			Size: fi.Size(),
+			open: func() (io.ReadCloser, error) { return s.fsys.Open(name) },
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/ulikunitz/xz"
+)
+
+// testMapFS returns the test releases as a MapFS, with the first
+// project of each release as zips and the others extracted.
+func testMapFS(t *testing.T, projects []testProject) fstest.MapFS {
+	t.Helper()
+	fsys := make(fstest.MapFS)
+	seen := make(map[string]bool)
+	for _, p := range projects {
+		ts := strings.TrimPrefix(p.release, "urlteam_")
+		name := p.release + "/" + p.meta.Name + "." + ts
+		if !seen[p.release] {
+			seen[p.release] = true
+			fsys[name+".zip"] = &fstest.MapFile{Data: makeTestZip(t, p)}
+			continue
+		}
+		meta, err := json.Marshal(&p.meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys[name+"/"+p.meta.Name+".meta.json.xz"] = &fstest.MapFile{Data: makeTestXZ(t, meta)}
+		for n, lines := range p.dumps {
+			dump := strings.Join(lines, "\n") + "\n"
+			fsys[name+"/"+strings.Repeat("x", n)+".txt.xz"] = &fstest.MapFile{Data: makeTestXZ(t, []byte(dump))}
+		}
+	}
+	return fsys
+}
+
+func makeTestXZ(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	xw, err := xz.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func collectSourcesFS(t *testing.T, fsys fs.FS, options *ProcessOptions) []string {
+	t.Helper()
+	var sources []string
+	err := ProcessReleasesFS(fsys, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		sources = append(sources, m.Name+":"+l.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sources
+}
+
+func TestProcessReleasesFS(t *testing.T) {
+	fsys := testMapFS(t, testReleases)
+	want := []string{"bitly_6:00000a", "bitly_6:0000a", "bitly_6:00000a", "bitly_6:00000b", "isgd:00000c"}
+	if got := collectSourcesFS(t, fsys, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("MapFS: got %q, want %q", got, want)
+	}
+	if got := collectSourcesFS(t, fsys, &ProcessOptions{Workers: 4}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MapFS concurrent: got %q, want %q", got, want)
+	}
+	want = []string{"isgd:00000c"}
+	if got := collectSourcesFS(t, fsys, &ProcessOptions{Projects: []string{"isgd"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MapFS isgd: got %q, want %q", got, want)
+	}
+
+	// An item zip containing project zips at its root
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, p := range testReleases[:2] {
+		w, err := zw.Create(p.meta.Name + "." + strings.TrimPrefix(p.release, "urlteam_") + ".zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(makeTestZip(t, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"bitly_6:00000a", "bitly_6:0000a", "bitly_6:00000a", "bitly_6:00000b"}
+	if got := collectSourcesFS(t, zr, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("zip-in-zip: got %q, want %q", got, want)
+	}
+}
+
+func TestProcessProjectFS(t *testing.T) {
+	fsys := testMapFS(t, testReleases)
+	var sums []*DumpSummary
+	var releases []string
+	options := &ProcessOptions{DumpDone: func(d *DumpSummary) { sums = append(sums, d) }}
+	name := "urlteam_2016-01-21-20-17-02/isgd.2016-01-21-20-17-02"
+	err := ProcessProjectFS(fsys, name, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		releases = append(releases, releaseFilename+":"+dumpFilename+":"+l.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{name + ":xxxxxx.txt.xz:00000c"}
+	if !reflect.DeepEqual(releases, want) {
+		t.Errorf("got %q, want %q", releases, want)
+	}
+	size := int64(len(fsys[name+"/xxxxxx.txt.xz"].Data))
+	if len(sums) != 1 || sums[0].Links != 1 || sums[0].CompressedSize != size {
+		t.Errorf("summaries: got %+v, want 1 link and %d compressed bytes", sums, size)
+	}
+}
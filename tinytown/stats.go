@@ -0,0 +1,260 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/csv"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+// Counts are the sizes of a set of link dumps.
+type Counts struct {
+	Dumps          int64 `json:"dumps"`
+	Links          int64 `json:"links"`
+	CompressedSize int64 `json:"compressed_size"` // bytes of xz-compressed dumps
+	Size           int64 `json:"size"`            // bytes of decompressed dumps
+}
+
+func (c *Counts) add(d *DumpSummary) {
+	c.Dumps++
+	c.Links += d.Links
+	c.CompressedSize += d.CompressedSize
+	c.Size += d.Size
+}
+
+// Stats are the sizes of the link dumps in a release directory.
+type Stats struct {
+	Total    Counts                   `json:"total"`
+	Projects map[string]*ProjectSizes `json:"projects"` // key: project name
+	Releases map[string]*Counts       `json:"releases"` // key: release identifier
+}
+
+// ProjectSizes are the sizes of the link dumps of a project.
+type ProjectSizes struct {
+	Counts
+	FirstRelease time.Time          `json:"first_release"`
+	LastRelease  time.Time          `json:"last_release"`
+	Releases     map[string]*Counts `json:"releases"`       // key: release identifier
+	Lengths      map[int]*Counts    `json:"shortcode_lens"` // key: shortcode length
+}
+
+// GetStats aggregates the sizes of the link dumps in the releases
+// selected by the options.
+func GetStats(root string, options *ProcessOptions) (*Stats, error) {
+	var o ProcessOptions
+	if options != nil {
+		o = *options
+	}
+	s := &Stats{
+		Projects: make(map[string]*ProjectSizes),
+		Releases: make(map[string]*Counts),
+	}
+	done := o.DumpDone
+	o.DumpDone = func(d *DumpSummary) {
+		s.add(d)
+		if done != nil {
+			done(d)
+		}
+	}
+	err := ProcessReleases(root, &o, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Stats) add(d *DumpSummary) {
+	release := releaseLabel(d.ReleaseFilename)
+	s.Total.add(d)
+	rc, ok := s.Releases[release]
+	if !ok {
+		rc = &Counts{}
+		s.Releases[release] = rc
+	}
+	rc.add(d)
+
+	p, ok := s.Projects[d.Meta.Name]
+	if !ok {
+		p = &ProjectSizes{Releases: make(map[string]*Counts), Lengths: make(map[int]*Counts)}
+		s.Projects[d.Meta.Name] = p
+	}
+	p.add(d)
+	if t, ok := parseReleaseTime(filepath.Base(d.ReleaseFilename)); ok {
+		if p.FirstRelease.IsZero() || t.Before(p.FirstRelease) {
+			p.FirstRelease = t
+		}
+		if t.After(p.LastRelease) {
+			p.LastRelease = t
+		}
+	}
+	prc, ok := p.Releases[release]
+	if !ok {
+		prc = &Counts{}
+		p.Releases[release] = prc
+	}
+	prc.add(d)
+	n := dumpShortcodeLen(d.DumpFilename)
+	lc, ok := p.Lengths[n]
+	if !ok {
+		lc = &Counts{}
+		p.Lengths[n] = lc
+	}
+	lc.add(d)
+}
+
+// StatsRow is a row of the flattened stats. Project, Release, and
+// ShortcodeLen are empty or zero for rows aggregated over them.
+type StatsRow struct {
+	Project      string
+	Release      string
+	ShortcodeLen int
+	Counts
+}
+
+// Rows flattens the stats into rows for the total, each release, each
+// project, and each release and shortcode length of each project, in a
+// stable order.
+func (s *Stats) Rows() []StatsRow {
+	rows := []StatsRow{{Counts: s.Total}}
+	for _, release := range sortedKeys(s.Releases) {
+		rows = append(rows, StatsRow{Release: release, Counts: *s.Releases[release]})
+	}
+	projects := make([]string, 0, len(s.Projects))
+	for project := range s.Projects {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	for _, project := range projects {
+		p := s.Projects[project]
+		rows = append(rows, StatsRow{Project: project, Counts: p.Counts})
+		for _, release := range sortedKeys(p.Releases) {
+			rows = append(rows, StatsRow{Project: project, Release: release, Counts: *p.Releases[release]})
+		}
+		lens := make([]int, 0, len(p.Lengths))
+		for n := range p.Lengths {
+			lens = append(lens, n)
+		}
+		sort.Ints(lens)
+		for _, n := range lens {
+			rows = append(rows, StatsRow{Project: project, ShortcodeLen: n, Counts: *p.Lengths[n]})
+		}
+	}
+	return rows
+}
+
+// WriteCSV writes the rows of the stats as CSV with a header.
+func (s *Stats) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "release", "shortcode_len", "dumps", "links", "compressed_size", "size"})
+	for _, r := range s.Rows() {
+		cw.Write(append(r.keys(), formatInts(r.Dumps, r.Links, r.CompressedSize, r.Size)...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// StatsDiff is the change in a row between two stats snapshots. A row
+// missing from a snapshot has zero counts.
+type StatsDiff struct {
+	Project      string
+	Release      string
+	ShortcodeLen int
+	Old, New     Counts
+}
+
+// CompareStats returns the rows that differ between two stats
+// snapshots.
+func CompareStats(old, new *Stats) []StatsDiff {
+	type key struct {
+		project, release string
+		shortcodeLen     int
+	}
+	diffs := make(map[key]*StatsDiff)
+	var keys []key
+	get := func(r StatsRow) *StatsDiff {
+		k := key{r.Project, r.Release, r.ShortcodeLen}
+		d, ok := diffs[k]
+		if !ok {
+			d = &StatsDiff{Project: r.Project, Release: r.Release, ShortcodeLen: r.ShortcodeLen}
+			diffs[k] = d
+			keys = append(keys, k)
+		}
+		return d
+	}
+	for _, r := range old.Rows() {
+		get(r).Old = r.Counts
+	}
+	for _, r := range new.Rows() {
+		get(r).New = r.Counts
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.project != b.project {
+			return a.project < b.project
+		}
+		if a.release != b.release {
+			return a.release < b.release
+		}
+		return a.shortcodeLen < b.shortcodeLen
+	})
+	var changed []StatsDiff
+	for _, k := range keys {
+		if d := diffs[k]; d.Old != d.New {
+			changed = append(changed, *d)
+		}
+	}
+	return changed
+}
+
+// WriteStatsDiffCSV writes stats differences as CSV with a header.
+func WriteStatsDiffCSV(w io.Writer, diffs []StatsDiff) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "release", "shortcode_len",
+		"old_dumps", "new_dumps", "old_links", "new_links",
+		"old_compressed_size", "new_compressed_size", "old_size", "new_size"})
+	for _, d := range diffs {
+		r := StatsRow{Project: d.Project, Release: d.Release, ShortcodeLen: d.ShortcodeLen}
+		cw.Write(append(r.keys(), formatInts(
+			d.Old.Dumps, d.New.Dumps, d.Old.Links, d.New.Links,
+			d.Old.CompressedSize, d.New.CompressedSize, d.Old.Size, d.New.Size)...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *StatsRow) keys() []string {
+	n := ""
+	if r.ShortcodeLen != 0 {
+		n = strconv.Itoa(r.ShortcodeLen)
+	}
+	return []string{r.Project, r.Release, n}
+}
+
+func formatInts(ints ...int64) []string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.FormatInt(n, 10)
+	}
+	return s
+}
+
+func sortedKeys(m map[string]*Counts) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	s, err := GetStats(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Total.Dumps != 4 || s.Total.Links != 5 || s.Total.CompressedSize == 0 {
+		t.Errorf("total: got %+v", s.Total)
+	}
+	wantSize := int64(len("00000a|http://example.com/2015\n"))
+	if c := s.Releases["urlteam_2015-12-31-20-17-02"]; c == nil || c.Links != 1 || c.Size != wantSize {
+		t.Errorf("release: got %+v, want 1 link of %d bytes", c, wantSize)
+	}
+	p := s.Projects["bitly_6"]
+	if p == nil || p.Links != 4 || len(p.Releases) != 2 ||
+		p.Lengths[5].Links != 1 || p.Lengths[6].Links != 3 ||
+		!p.FirstRelease.Equal(time.Date(2015, 12, 31, 20, 17, 2, 0, time.UTC)) ||
+		!p.LastRelease.Equal(time.Date(2016, 1, 21, 20, 17, 2, 0, time.UTC)) {
+		t.Errorf("bitly_6: got %+v", p)
+	}
+
+	concurrent, err := GetStats(root, &ProcessOptions{Workers: 4, Unordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(concurrent, s) {
+		t.Errorf("concurrent stats differ from sequential")
+	}
+
+	var b bytes.Buffer
+	if err := s.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(s.Rows())+1 || !strings.HasPrefix(lines[1], ",,,4,5,") {
+		t.Errorf("CSV: got %q", lines)
+	}
+}
+
+func TestCompareStats(t *testing.T) {
+	old, err := GetStats(writeTestReleases(t, testReleases[:1]), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := GetStats(writeTestReleases(t, testReleases[:2]), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs := CompareStats(old, new)
+	var keys []string
+	for _, d := range diffs {
+		keys = append(keys, d.Project+"/"+d.Release+"/"+string(rune('0'+d.ShortcodeLen)))
+	}
+	want := []string{
+		"//0",
+		"/urlteam_2016-01-21-20-17-02/0",
+		"bitly_6//0",
+		"bitly_6//5",
+		"bitly_6//6",
+		"bitly_6/urlteam_2016-01-21-20-17-02/0",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %q, want %q", keys, want)
+	}
+	if d := diffs[0]; d.Old.Links != 1 || d.New.Links != 4 {
+		t.Errorf("total: got %+v", d)
+	}
+	if len(CompareStats(new, new)) != 0 {
+		t.Error("expected no differences for identical stats")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/ia"
+	"github.com/andrewarchi/urlhero/progress"
+)
+
+// SyncReleases downloads the terroroftinytown releases that are not yet
+// recorded in the state file, validates them, and calls fn, if non-nil,
+// on each project zip in the new releases. A release is recorded in the
+// state file once it has been processed, so an interrupted sync resumes
+// with the first unfinished release. The observer, if non-nil, is
+// notified of each release and of each file validated. The identifiers
+// of the synced releases are returned.
+func SyncReleases(dir, stateFile string, obs progress.Observer, fn func(filename string) error) ([]string, error) {
+	known, err := readSyncState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := GetReleaseIDs()
+	if err != nil {
+		return nil, err
+	}
+	var newIDs []string
+	for _, id := range ids {
+		if _, ok := known[id]; !ok {
+			newIDs = append(newIDs, id)
+		}
+	}
+	SortReleases(newIDs)
+
+	obs = progress.Or(obs)
+	for i, id := range newIDs {
+		e := &progress.Event{Kind: progress.Release, Name: id, Index: i, Total: len(newIDs)}
+		obs.Start(e)
+		err := syncRelease(id, dir, stateFile, obs, fn)
+		obs.Finish(e, err)
+		if err != nil {
+			return newIDs[:i], err
+		}
+	}
+	return newIDs, nil
+}
+
+func syncRelease(id, dir, stateFile string, obs progress.Observer, fn func(filename string) error) error {
+	zips, err := downloadRelease(id, dir, obs)
+	if err != nil {
+		return err
+	}
+	if fn != nil {
+		for _, filename := range zips {
+			if err := fn(filename); err != nil {
+				return err
+			}
+		}
+	}
+	return appendSyncState(stateFile, id)
+}
+
+// downloadRelease downloads the original files of a release over HTTP,
+// validates them against the checksums in *_files.xml, and returns the
+// filenames of the project zips.
+func downloadRelease(id, dir string, obs progress.Observer) ([]string, error) {
+	itemDir := filepath.Join(dir, id)
+	if err := os.MkdirAll(itemDir, 0o777); err != nil {
+		return nil, err
+	}
+	filesName := id + "_files.xml"
+	if err := saveFile(itemURL(id, filesName), filepath.Join(itemDir, filesName)); err != nil {
+		return nil, err
+	}
+	files, err := ia.ReadFileMeta(itemDir)
+	if err != nil {
+		return nil, err
+	}
+	// Only the original files are downloaded, so others, such as
+	// *_archive.torrent, are not validated.
+	var downloaded []ia.FileMeta
+	var zips []string
+	for _, file := range files {
+		if file.Name == filesName || file.Source != "original" || file.Private {
+			continue
+		}
+		filename := filepath.Join(itemDir, filepath.FromSlash(file.Name))
+		if err := saveFile(itemURL(id, file.Name), filename); err != nil {
+			return nil, err
+		}
+		downloaded = append(downloaded, file)
+		if strings.HasSuffix(file.Name, ".zip") {
+			zips = append(zips, filename)
+		}
+	}
+	if err := ia.ValidateFiles(itemDir, downloaded, obs); err != nil {
+		return nil, err
+	}
+	return zips, nil
+}
+
+func itemURL(id, name string) string {
+	return Archive + "/download/" + id + "/" + name
+}
+
+// readSyncState reads the set of release identifiers in a state file,
+// which lists one identifier per line. A missing state file is empty.
+func readSyncState(filename string) (map[string]struct{}, error) {
+	known := make(map[string]struct{})
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if id := strings.TrimSpace(s.Text()); id != "" {
+			known[id] = struct{}{}
+		}
+	}
+	return known, s.Err()
+}
+
+func appendSyncState(filename, id string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, id); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrewarchi/urlhero/ia"
+)
+
+// serveTestArchive serves the release items in dir as archive.org
+// would and points Archive at the server for the duration of the test.
+// Downloads of files are passed through wrap, if non-nil.
+func serveTestArchive(t *testing.T, dir string, ids []string, wrap func(http.Handler) http.Handler) {
+	t.Helper()
+	var files http.Handler = http.StripPrefix("/download/", http.FileServer(http.Dir(dir)))
+	if wrap != nil {
+		files = wrap(files)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/search/v1/scrape" {
+			items := make([]string, len(ids))
+			for i, id := range ids {
+				items[i] = `{"identifier":"` + id + `"}`
+			}
+			fmt.Fprintf(w, `{"items":[%s],"count":%d,"total":%d}`, strings.Join(items, ","), len(ids), len(ids))
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	archive := Archive
+	t.Cleanup(func() { Archive = archive })
+	Archive = ts.URL
+}
+
+func TestSyncReleases(t *testing.T) {
+	const id = "urlteam_2016-01-21-20-17-02"
+	remote := t.TempDir()
+	itemDir := filepath.Join(remote, id)
+	if err := os.MkdirAll(itemDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	zipName := "bitly_6.2016-01-21-20-17-02.zip"
+	if err := os.WriteFile(filepath.Join(itemDir, zipName), makeTestZip(t, testReleases[1]), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ia.ScanFileMeta(itemDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The torrent is generated by the Internet Archive and is not served
+	// here, so it must not be downloaded or validated.
+	files = append(files, ia.FileMeta{Name: id + "_archive.torrent", Source: "metadata", Size: 100, MD5: []byte{0}})
+	if err := ia.WriteFileMeta(itemDir, files); err != nil {
+		t.Fatal(err)
+	}
+	serveTestArchive(t, remote, []string{id}, nil)
+
+	local := t.TempDir()
+	stateFile := filepath.Join(local, "state.txt")
+	var zips []string
+	synced, err := SyncReleases(local, stateFile, nil, func(filename string) error {
+		zips = append(zips, filepath.Base(filename))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 1 || synced[0] != id || len(zips) != 1 || zips[0] != zipName {
+		t.Fatalf("got synced %q and zips %q", synced, zips)
+	}
+	if _, err := os.Stat(filepath.Join(local, id, id+"_archive.torrent")); !os.IsNotExist(err) {
+		t.Errorf("torrent was downloaded: %v", err)
+	}
+
+	synced, err = SyncReleases(local, stateFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 0 {
+		t.Errorf("resync: got synced %q", synced)
+	}
+}
+
+func TestSaveFileInterrupted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent, so that the connection is closed
+		// early.
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+	}))
+	defer ts.Close()
+	filename := filepath.Join(t.TempDir(), "file.zip")
+	if err := saveFile(ts.URL, filename); err == nil {
+		t.Fatal("expected error for interrupted download")
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got files %v after interrupted download", entries)
+	}
+}
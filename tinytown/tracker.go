@@ -8,14 +8,35 @@ package tinytown
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/andrewarchi/browser/jsonutil"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 // Tracker is the base URL of the Terror of Tiny Town tracker instance.
 // This can be changed to use an alternate tracker.
 var Tracker = "https://tracker.archiveteam.org:1338"
 
+// trackerClient keeps the session cookie set by TrackerLogin.
+var trackerClient = newTrackerClient()
+
+func newTrackerClient() *http.Client {
+	jar, _ := cookiejar.New(nil) // never fails without options
+	return &http.Client{Jar: jar}
+}
+
+// Health is the status of the tracker and the progress of its projects.
 type Health struct {
 	HTTPStatusCode    int                     // e.g., 200
 	HTTPStatusMessage string                  // e.g., "OK"
@@ -24,18 +45,15 @@ type Health struct {
 	ProjectStats      map[string]ProjectStats // key: project ID
 }
 
+// ProjectStats is the number of shortcodes scanned and URLs found in a
+// project.
 type ProjectStats struct {
 	Found   int64
 	Scanned int64
 }
 
+// GetHealth queries the health of the tracker.
 func GetHealth() (*Health, error) {
-	resp, err := httpGet(Tracker + "/api/health")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
 	var health struct {
 		HTTPStatusCode    int                 `json:"http_status_code"`
 		HTTPStatusMessage string              `json:"http_status_message"`
@@ -43,7 +61,7 @@ func GetHealth() (*Health, error) {
 		Projects          []string            `json:"projects"`
 		ProjectStats      map[string][2]int64 `json:"project_stats"`
 	}
-	if err := jsonutil.Decode(resp.Body, &health); err != nil {
+	if err := getTrackerJSON("/api/health", &health); err != nil {
 		return nil, err
 	}
 
@@ -69,3 +87,497 @@ func GetHealth() (*Health, error) {
 		ProjectStats:      stats,
 	}, nil
 }
+
+// TrackerLogin logs in to the tracker as an admin. The project
+// settings, queue, claims, and results pages are only served to admins,
+// so this must be called before querying them. The session cookie is
+// kept for later requests to the tracker.
+func TrackerLogin(username, password string) error {
+	doc, err := getTrackerHTML("/admin/login")
+	if err != nil {
+		return err
+	}
+	form := url.Values{"username": {username}, "password": {password}}
+	// The tracker rejects forms posted without the token from the page.
+	if xsrf, ok := formValues(doc)["_xsrf"]; ok {
+		form.Set("_xsrf", xsrf)
+	}
+	resp, err := trackerClient.PostForm(Tracker+"/admin/login", form)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tinytown: login: http status %s", resp.Status)
+	}
+	// A successful login redirects to the admin overview, while a failed
+	// one renders the form again.
+	if isLoginPage(resp) {
+		return errors.New("tinytown: login: invalid username or password")
+	}
+	return nil
+}
+
+// GetProjectSettings queries the settings of a project from its
+// settings page on the tracker. It requires TrackerLogin.
+func GetProjectSettings(project string) (*Meta, error) {
+	doc, err := getTrackerHTML("/project/" + url.PathEscape(project) + "/settings")
+	if err != nil {
+		return nil, err
+	}
+	f := settingsForm{values: formValues(doc)}
+	m := &Meta{
+		Name:              project,
+		MinVersion:        f.int("min_version"),
+		MinClientVersion:  f.int("min_client_version"),
+		Alphabet:          f.string("alphabet"),
+		URLTemplate:       f.string("url_template"),
+		RequestDelay:      f.float("request_delay"),
+		RedirectCodes:     f.ints("redirect_codes"),
+		NoRedirectCodes:   f.ints("no_redirect_codes"),
+		UnavailableCodes:  f.ints("unavailable_codes"),
+		BannedCodes:       f.ints("banned_codes"),
+		BodyRegex:         f.string("body_regex"),
+		LocationAntiRegex: f.string("location_anti_regex"),
+		Method:            f.string("method"),
+		Enabled:           f.bool("enabled"),
+		Autoqueue:         f.bool("autoqueue"),
+		NumCountPerItem:   f.int("num_count_per_item"),
+		MaxNumItems:       f.int("max_num_items"),
+		LowerSequenceNum:  f.int64("lower_sequence_num"),
+		AutoreleaseTime:   f.int("autorelease_time"),
+	}
+	if f.err != nil {
+		return nil, fmt.Errorf("tinytown: %s settings: %w", project, f.err)
+	}
+	return m, nil
+}
+
+// GetProjects queries the settings of every project listed in the
+// health of the tracker, including disabled ones. It requires
+// TrackerLogin.
+func GetProjects() ([]Meta, error) {
+	health, err := GetHealth()
+	if err != nil {
+		return nil, err
+	}
+	projects := make([]Meta, len(health.Projects))
+	for i, p := range health.Projects {
+		m, err := GetProjectSettings(p)
+		if err != nil {
+			return nil, err
+		}
+		projects[i] = *m
+	}
+	return projects, nil
+}
+
+// QueueStatus is the state of the item queue of a project.
+type QueueStatus struct {
+	Project string
+	Queued  int64   // items waiting to be claimed
+	Claimed int64   // items claimed by clients and not yet done
+	Claims  []Claim // currently claimed items
+}
+
+// Claim is an item of a project claimed by a client.
+type Claim struct {
+	ItemID    int64
+	Username  string
+	ClaimedAt time.Time
+	Lower     int64 // first sequence number of the item
+	Upper     int64 // last sequence number of the item, inclusive
+}
+
+// GetQueueStatus queries the queue and claims pages of a project. The
+// queue page lists every item not yet done, of which those with a claim
+// time are claimed, and the claims page lists the claimed items with
+// their clients. It requires TrackerLogin.
+func GetQueueStatus(project string) (*QueueStatus, error) {
+	base := "/project/" + url.PathEscape(project)
+	doc, err := getTrackerHTML(base + "/queue")
+	if err != nil {
+		return nil, err
+	}
+	items, err := readTable(doc, "id", "lower_sequence_num", "upper_sequence_num")
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: %s queue: %w", project, err)
+	}
+	var queued int64
+	for _, item := range items {
+		if item["datetime_claimed"] == "" {
+			queued++
+		}
+	}
+
+	doc, err = getTrackerHTML(base + "/claims")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readTable(doc, "id", "username", "datetime_claimed", "lower_sequence_num", "upper_sequence_num")
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: %s claims: %w", project, err)
+	}
+	claims := make([]Claim, len(rows))
+	for i, row := range rows {
+		c := &claims[i]
+		c.Username = row["username"]
+		if c.ItemID, err = strconv.ParseInt(row["id"], 10, 64); err == nil {
+			if c.Lower, err = strconv.ParseInt(row["lower_sequence_num"], 10, 64); err == nil {
+				if c.Upper, err = strconv.ParseInt(row["upper_sequence_num"], 10, 64); err == nil {
+					c.ClaimedAt, err = parseTrackerTime(row["datetime_claimed"])
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tinytown: %s claims: %w", project, err)
+		}
+	}
+	return &QueueStatus{
+		Project: project,
+		Queued:  queued,
+		Claimed: int64(len(claims)),
+		Claims:  claims,
+	}, nil
+}
+
+// Result is a shortcode resolved by a client, not yet in a release.
+type Result struct {
+	Project   string
+	Shortcode string
+	URL       string
+	Encoding  string // e.g., "ascii"
+	Time      time.Time
+}
+
+// GetRecentResults queries the most recent results from the results
+// page of the tracker. It requires TrackerLogin.
+func GetRecentResults() ([]Result, error) {
+	doc, err := getTrackerHTML("/admin/results")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readTable(doc, "project", "shortcode", "url", "encoding", "datetime")
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: results: %w", err)
+	}
+	results := make([]Result, len(rows))
+	for i, row := range rows {
+		t, err := parseTrackerTime(row["datetime"])
+		if err != nil {
+			return nil, fmt.Errorf("tinytown: results: %w", err)
+		}
+		results[i] = Result{row["project"], row["shortcode"], row["url"], row["encoding"], t}
+	}
+	return results, nil
+}
+
+// ReleaseStatus is the number of results on the tracker that have not
+// yet been exported in a release. Results are removed from the tracker
+// as they are exported.
+type ReleaseStatus struct {
+	PendingResults int64            // results not yet exported
+	ProjectResults map[string]int64 // results not yet exported, by project ID
+}
+
+// GetReleaseStatus queries the results pending for the next release
+// from the admin overview of the tracker, which counts the results of
+// each project. It requires TrackerLogin.
+func GetReleaseStatus() (*ReleaseStatus, error) {
+	doc, err := getTrackerHTML("/admin/")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readTable(doc, "project", "results")
+	if err != nil {
+		return nil, fmt.Errorf("tinytown: release status: %w", err)
+	}
+	status := &ReleaseStatus{ProjectResults: make(map[string]int64, len(rows))}
+	for _, row := range rows {
+		n, err := strconv.ParseInt(strings.ReplaceAll(row["results"], ",", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("tinytown: release status: %w", err)
+		}
+		status.ProjectResults[row["project"]] += n
+		status.PendingResults += n
+	}
+	return status, nil
+}
+
+func getTrackerJSON(path string, v interface{}) error {
+	resp, err := trackerGet(path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return jsonutil.Decode(resp.Body, v)
+}
+
+func getTrackerHTML(path string) (*html.Node, error) {
+	resp, err := trackerGet(path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Admin pages redirect to the login form when not logged in.
+	if isLoginPage(resp) && !strings.HasSuffix(path, "/admin/login") {
+		return nil, fmt.Errorf("tinytown: %s: not logged in to tracker", path)
+	}
+	return html.Parse(resp.Body)
+}
+
+func trackerGet(path string) (*http.Response, error) {
+	resp, err := trackerClient.Get(Tracker + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("tinytown: http status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func isLoginPage(resp *http.Response) bool {
+	return strings.HasSuffix(resp.Request.URL.Path, "/admin/login")
+}
+
+// formValues returns the values of the controls in the forms of a page,
+// keyed by name, as they would be submitted. Unchecked checkboxes are
+// omitted.
+func formValues(doc *html.Node) map[string]string {
+	values := make(map[string]string)
+	walkHTML(doc, func(n *html.Node) bool {
+		name, ok := htmlAttr(n, "name")
+		if !ok {
+			return true
+		}
+		switch n.DataAtom {
+		case atom.Input:
+			typ, _ := htmlAttr(n, "type")
+			value, hasValue := htmlAttr(n, "value")
+			if typ == "checkbox" || typ == "radio" {
+				if _, checked := htmlAttr(n, "checked"); !checked {
+					return true
+				}
+				if !hasValue {
+					value = "on"
+				}
+			}
+			values[name] = value
+		case atom.Textarea:
+			values[name] = htmlText(n)
+		case atom.Select:
+			var value string
+			first := true
+			walkHTML(n, func(o *html.Node) bool {
+				if o.DataAtom != atom.Option {
+					return true
+				}
+				v, ok := htmlAttr(o, "value")
+				if !ok {
+					v = strings.TrimSpace(htmlText(o))
+				}
+				if _, selected := htmlAttr(o, "selected"); selected || first {
+					value = v
+				}
+				first = false
+				return false
+			})
+			values[name] = value
+			return false
+		}
+		return true
+	})
+	return values
+}
+
+// settingsForm parses the values of a project settings form. The first
+// error is kept.
+type settingsForm struct {
+	values map[string]string
+	err    error
+}
+
+func (f *settingsForm) string(name string) string {
+	v, ok := f.values[name]
+	if !ok && f.err == nil {
+		f.err = fmt.Errorf("no field %q", name)
+	}
+	return v
+}
+
+func (f *settingsForm) bool(name string) bool {
+	_, ok := f.values[name]
+	return ok
+}
+
+func (f *settingsForm) int(name string) int {
+	return int(f.int64(name))
+}
+
+func (f *settingsForm) int64(name string) int64 {
+	v := strings.TrimSpace(f.string(name))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && f.err == nil {
+		f.err = fmt.Errorf("field %q: %w", name, err)
+	}
+	return n
+}
+
+func (f *settingsForm) float(name string) float64 {
+	v := strings.TrimSpace(f.string(name))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil && f.err == nil {
+		f.err = fmt.Errorf("field %q: %w", name, err)
+	}
+	return n
+}
+
+// ints parses a list of HTTP codes, separated by spaces or commas.
+func (f *settingsForm) ints(name string) []int {
+	fields := strings.FieldsFunc(f.string(name), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	codes := make([]int, 0, len(fields))
+	for _, field := range fields {
+		code, err := strconv.Atoi(field)
+		if err != nil && f.err == nil {
+			f.err = fmt.Errorf("field %q: %w", name, err)
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// readTable reads the rows of the first table on a page with all of the
+// given columns. Cells are keyed by their column heading, normalized
+// like "Lower sequence num" to "lower_sequence_num".
+func readTable(doc *html.Node, columns ...string) ([]map[string]string, error) {
+	var rows []map[string]string
+	found := false
+	walkHTML(doc, func(n *html.Node) bool {
+		if found || n.DataAtom != atom.Table {
+			return !found
+		}
+		var heading []string
+		var body [][]string
+		walkHTML(n, func(tr *html.Node) bool {
+			if tr.DataAtom != atom.Tr {
+				return true
+			}
+			var cells []string
+			isHeading := false
+			for c := tr.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom == atom.Th || c.DataAtom == atom.Td {
+					cells = append(cells, strings.TrimSpace(htmlText(c)))
+					isHeading = isHeading || c.DataAtom == atom.Th
+				}
+			}
+			if isHeading && heading == nil {
+				heading = cells
+			} else if !isHeading {
+				body = append(body, cells)
+			}
+			return false
+		})
+		index := make(map[string]int, len(heading))
+		for i, h := range heading {
+			index[tableColumn(h)] = i
+		}
+		for _, col := range columns {
+			if _, ok := index[col]; !ok {
+				return false
+			}
+		}
+		found = true
+		for _, cells := range body {
+			row := make(map[string]string, len(index))
+			for col, i := range index {
+				if i < len(cells) {
+					row[col] = cells[i]
+				}
+			}
+			rows = append(rows, row)
+		}
+		return false
+	})
+	if !found {
+		return nil, fmt.Errorf("no table with columns %s", strings.Join(columns, ", "))
+	}
+	return rows, nil
+}
+
+// tableColumn normalizes a column heading to lowercase words joined by
+// underscores.
+func tableColumn(heading string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(heading), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "_")
+}
+
+// parseTrackerTime parses a time on a tracker page, which is formatted
+// as a UTC Python datetime, or as Unix seconds. Empty strings are the
+// zero time.
+func parseTrackerTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return unixTime(sec), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
+
+// unixTime converts fractional Unix seconds, as used by the tracker, to
+// a time.
+func unixTime(sec float64) time.Time {
+	s, frac := math.Modf(sec)
+	return time.Unix(int64(s), int64(frac*1e9)).UTC()
+}
+
+// walkHTML calls fn on each element in pre-order, descending into its
+// children when fn returns true.
+func walkHTML(n *html.Node, fn func(*html.Node) bool) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && !fn(c) {
+			continue
+		}
+		walkHTML(c, fn)
+	}
+}
+
+func htmlAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(n)
+	return b.String()
+}
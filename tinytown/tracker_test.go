@@ -0,0 +1,169 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trackerPages are stand-ins for the pages served by the tracker, keyed
+// by path. Paths under /admin/ and /project/ require a login.
+var trackerPages = map[string]string{
+	"/api/health": `{"http_status_code":200,"http_status_message":"OK","git_hash":"b'80ffc5'",` +
+		`"projects":["isgd"],"project_stats":{"isgd":[10,200]}}`,
+	"/admin/login": `<html><body><form method="post" action="/admin/login">
+<input type="hidden" name="_xsrf" value="2|token">
+<input name="username"><input type="password" name="password">
+<input type="submit" value="Log in"></form></body></html>`,
+	"/admin/": `<html><body><h1>Overview</h1>
+<table><thead><tr><th>Project</th><th>Results</th></tr></thead>
+<tbody><tr><td><a href="/project/isgd">isgd</a></td><td>1,200</td></tr>
+<tr><td><a href="/project/bitly_6">bitly_6</a></td><td>5</td></tr></tbody></table></body></html>`,
+	"/admin/results": `<html><body><table>
+<tr><th>ID</th><th>Project</th><th>Shortcode</th><th>URL</th><th>Encoding</th><th>Datetime</th></tr>
+<tr><td>1</td><td>isgd</td><td>0a</td><td>http://example.com/</td><td>ascii</td><td>2021-01-21 20:17:02.500000</td></tr>
+</table></body></html>`,
+	"/project/isgd/settings": `<html><body><form method="post">
+<input name="alphabet" value="0123456789abcdef">
+<input name="min_version" value="1"><input name="min_client_version" value="2">
+<input name="url_template" value="https://is.gd/{shortcode}">
+<input name="request_delay" value="0.5">
+<input name="redirect_codes" value="301 302"><input name="no_redirect_codes" value="404">
+<input name="unavailable_codes" value=""><input name="banned_codes" value="403, 420">
+<textarea name="body_regex"></textarea><textarea name="location_anti_regex">^https?://is\.gd/</textarea>
+<select name="method"><option value="head">HEAD</option><option value="get" selected>GET</option></select>
+<input type="checkbox" name="enabled" checked><input type="checkbox" name="autoqueue">
+<input name="num_count_per_item" value="50"><input name="max_num_items" value="100">
+<input name="lower_sequence_num" value="1000"><input name="autorelease_time" value="1800">
+</form></body></html>`,
+	"/project/isgd/queue": `<html><body><table>
+<tr><th>ID</th><th>Lower sequence num</th><th>Upper sequence num</th><th>Datetime claimed</th></tr>
+<tr><td>6</td><td>50</td><td>99</td><td></td></tr>
+<tr><td>7</td><td>100</td><td>149</td><td>2021-01-21 20:17:02.500000</td></tr>
+<tr><td>8</td><td>150</td><td>199</td><td></td></tr>
+</table></body></html>`,
+	"/project/isgd/claims": `<html><body><table>
+<tr><th>ID</th><th>Username</th><th>Datetime claimed</th><th>Lower sequence num</th><th>Upper sequence num</th></tr>
+<tr><td>7</td><td>alice</td><td>2021-01-21 20:17:02.500000</td><td>100</td><td>149</td></tr>
+</table></body></html>`,
+}
+
+func serveTestTracker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/login" && r.Method == http.MethodPost {
+			if r.FormValue("_xsrf") != "2|token" || r.FormValue("password") != "hunter2" {
+				w.Write([]byte(trackerPages["/admin/login"]))
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "user", Value: r.FormValue("username"), Path: "/"})
+			http.Redirect(w, r, "/admin/", http.StatusFound)
+			return
+		}
+		body, ok := trackerPages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/project/") || r.URL.Path != "/admin/login" && strings.HasPrefix(r.URL.Path, "/admin/") {
+			if _, err := r.Cookie("user"); err != nil {
+				http.Redirect(w, r, "/admin/login?next="+r.URL.Path, http.StatusFound)
+				return
+			}
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	tracker, client := Tracker, trackerClient
+	t.Cleanup(func() { Tracker, trackerClient = tracker, client })
+	Tracker, trackerClient = ts.URL, newTrackerClient()
+}
+
+func TestTrackerHealth(t *testing.T) {
+	serveTestTracker(t)
+	health, err := GetHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(health.ProjectStats, map[string]ProjectStats{"isgd": {10, 200}}) || len(health.GitHash) != 3 {
+		t.Errorf("GetHealth: got %+v", health)
+	}
+}
+
+func TestTrackerAdmin(t *testing.T) {
+	serveTestTracker(t)
+	if _, err := GetProjectSettings("isgd"); err == nil {
+		t.Error("GetProjectSettings: expected error before login")
+	}
+	if err := TrackerLogin("alice", "wrong"); err == nil {
+		t.Error("TrackerLogin: expected error for wrong password")
+	}
+	if err := TrackerLogin("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := GetProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMeta := Meta{
+		Name:              "isgd",
+		MinVersion:        1,
+		MinClientVersion:  2,
+		Alphabet:          "0123456789abcdef",
+		URLTemplate:       "https://is.gd/{shortcode}",
+		RequestDelay:      0.5,
+		RedirectCodes:     []int{301, 302},
+		NoRedirectCodes:   []int{404},
+		UnavailableCodes:  []int{},
+		BannedCodes:       []int{403, 420},
+		LocationAntiRegex: `^https?://is\.gd/`,
+		Method:            "get",
+		Enabled:           true,
+		NumCountPerItem:   50,
+		MaxNumItems:       100,
+		LowerSequenceNum:  1000,
+		AutoreleaseTime:   1800,
+	}
+	if !reflect.DeepEqual(projects, []Meta{wantMeta}) {
+		t.Errorf("GetProjects: got %+v, want %+v", projects, []Meta{wantMeta})
+	}
+
+	queue, err := GetQueueStatus("isgd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueue := &QueueStatus{"isgd", 2, 1, []Claim{{7, "alice", time.Date(2021, 1, 21, 20, 17, 2, 5e8, time.UTC), 100, 149}}}
+	if !reflect.DeepEqual(queue, wantQueue) {
+		t.Errorf("GetQueueStatus: got %+v, want %+v", queue, wantQueue)
+	}
+	if _, err := GetQueueStatus("missing"); err == nil {
+		t.Error("GetQueueStatus: expected error for missing project")
+	}
+
+	results, err := GetRecentResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResults := []Result{{"isgd", "0a", "http://example.com/", "ascii", time.Date(2021, 1, 21, 20, 17, 2, 5e8, time.UTC)}}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Errorf("GetRecentResults: got %+v, want %+v", results, wantResults)
+	}
+
+	release, err := GetReleaseStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRelease := &ReleaseStatus{1205, map[string]int64{"isgd": 1200, "bitly_6": 5}}
+	if !reflect.DeepEqual(release, wantRelease) {
+		t.Errorf("GetReleaseStatus: got %+v, want %+v", release, wantRelease)
+	}
+}
@@ -7,12 +7,57 @@
 package tinytown
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"time"
 
 	trpc "github.com/hekmon/transmissionrpc"
+
+	"github.com/andrewarchi/urlhero/ia"
+	"github.com/andrewarchi/urlhero/progress"
 )
 
-func DownloadTransmission(c *trpc.Client, dir string) error {
+// TransmissionOptions selects the project zips downloaded by
+// Transmission and controls monitoring. A nil *TransmissionOptions
+// downloads everything with the default intervals.
+type TransmissionOptions struct {
+	Projects     []string // project name patterns of zips to download, e.g., "bitly_*"; all when empty
+	SkipProjects []string // project name patterns of zips to skip
+
+	PollInterval time.Duration // interval between RPC polls; defaults to 30s
+	// StallTimeout is the duration without progress before a torrent is
+	// stalled; defaults to 30m. Torrents waiting in the Transmission
+	// queue or being checked are not stalled.
+	StallTimeout time.Duration
+	// Progress is called after every poll.
+	Progress func(p *TransmissionProgress)
+	// Observer is notified as each release is added or skipped.
+	Observer progress.Observer
+}
+
+// TransmissionProgress is the aggregate progress of the torrents added
+// by DownloadTransmission.
+type TransmissionProgress struct {
+	Torrents      int
+	Done          int
+	SizeWhenDone  int64    // bytes of wanted files
+	LeftUntilDone int64    // bytes of wanted files not yet downloaded
+	RateDownload  int64    // bytes per second
+	Stalled       []string // names of torrents without recent progress
+	Paused        []string // names of unfinished torrents stopped in Transmission
+	Errors        []string // errors reported by Transmission, by torrent
+	Stuck         int      // unfinished torrents that are stalled, paused, or errored
+}
+
+// DownloadTransmission adds the terroroftinytown releases to
+// Transmission, wanting only the zips of the selected projects, and
+// polls until all wanted files are downloaded. Torrents already in
+// Transmission are monitored, but their wanted files are not changed.
+// Polling stops with an error when every unfinished torrent is stuck,
+// or with the context's error when it is done.
+func DownloadTransmission(ctx context.Context, c *trpc.Client, dir string, options *TransmissionOptions) error {
 	if err := checkVersion(c); err != nil {
 		return err
 	}
@@ -20,17 +65,195 @@ func DownloadTransmission(c *trpc.Client, dir string) error {
 	if err != nil {
 		return err
 	}
+	existing, err := c.TorrentGet([]string{"id", "hashString"}, nil)
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]int64, len(existing))
+	for _, t := range existing {
+		hashes[*t.HashString] = *t.ID
+	}
+
+	var obs progress.Observer = progress.Nop{}
+	if options != nil {
+		obs = progress.Or(options.Observer)
+	}
+	var torrentIDs []int64
 	for i, id := range ids {
-		fmt.Printf("(%d/%d) Adding %s\n", i+1, len(ids), id)
+		e := &progress.Event{Kind: progress.Release, Name: id, Index: i, Total: len(ids)}
 		filename, err := saveTorrentFile(id, dir)
 		if err != nil {
 			return err
 		}
-		if _, err := c.TorrentAddFileDownloadDir(filename, dir); err != nil {
+		t, err := ia.ReadTorrent(filename)
+		if err != nil {
+			return err
+		}
+		if tid, ok := hashes[hex.EncodeToString(t.InfoHash[:])]; ok {
+			e.Message = "skipped: already added"
+			obs.Finish(e, nil)
+			torrentIDs = append(torrentIDs, tid)
+			continue
+		}
+		wanted, unwanted := options.wantedFiles(t)
+		if len(wanted) == 0 {
+			e.Message = "skipped: no selected projects"
+			obs.Finish(e, nil)
+			continue
+		}
+		e.Message = fmt.Sprintf("adding %d of %d files", len(wanted), len(t.Files))
+		obs.Start(e)
+		e.Message = ""
+		b64, err := trpc.File2Base64(filename)
+		if err != nil {
+			obs.Finish(e, err)
+			return err
+		}
+		added, err := c.TorrentAdd(&trpc.TorrentAddPayload{
+			MetaInfo:      &b64,
+			DownloadDir:   &dir,
+			FilesWanted:   wanted,
+			FilesUnwanted: unwanted,
+		})
+		obs.Finish(e, err)
+		if err != nil {
 			return err
 		}
+		torrentIDs = append(torrentIDs, *added.ID)
 	}
-	return nil
+	return monitorTransmission(ctx, c, torrentIDs, options)
+}
+
+// wantedFiles returns the indices of the files in a torrent to download
+// and to skip. Zips are wanted when their project is selected and other
+// files, which are small metadata, are always wanted. No files are
+// wanted when no zips are selected.
+func (o *TransmissionOptions) wantedFiles(t *ia.Torrent) (wanted, unwanted []int64) {
+	var po *ProcessOptions
+	if o != nil {
+		po = &ProcessOptions{Projects: o.Projects, SkipProjects: o.SkipProjects}
+	}
+	anyZip := false
+	for i, f := range t.Files {
+		if !po.wantFile(f.Name) {
+			unwanted = append(unwanted, int64(i))
+			continue
+		}
+		wanted = append(wanted, int64(i))
+		if strings.HasSuffix(f.Name, ".zip") {
+			anyZip = true
+		}
+	}
+	if !anyZip {
+		return nil, unwanted
+	}
+	return wanted, unwanted
+}
+
+// monitorTransmission polls the torrents until all wanted files are
+// downloaded or no unfinished torrent can progress.
+func monitorTransmission(ctx context.Context, c *trpc.Client, ids []int64, options *TransmissionOptions) error {
+	var o TransmissionOptions
+	if options != nil {
+		o = *options
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 30 * time.Second
+	}
+	if o.StallTimeout <= 0 {
+		o.StallTimeout = 30 * time.Minute
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	m := newTransmissionMonitor(o.StallTimeout)
+	fields := []string{"id", "name", "status", "leftUntilDone", "sizeWhenDone", "rateDownload", "error", "errorString"}
+	ticker := time.NewTicker(o.PollInterval)
+	defer ticker.Stop()
+	for {
+		torrents, err := c.TorrentGet(fields, ids)
+		if err != nil {
+			return err
+		}
+		p := m.update(torrents, time.Now())
+		if o.Progress != nil {
+			o.Progress(p)
+		}
+		if p.Done == p.Torrents {
+			return nil
+		}
+		if p.Done+p.Stuck == p.Torrents {
+			return fmt.Errorf("tinytown: all %d unfinished torrents are stalled, paused, or errored", p.Stuck)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// transmissionMonitor tracks the progress of torrents between polls to
+// detect stalls.
+type transmissionMonitor struct {
+	stallTimeout time.Duration
+	left         map[int64]int64     // bytes left at last progress
+	progressed   map[int64]time.Time // time of last progress
+}
+
+func newTransmissionMonitor(stallTimeout time.Duration) *transmissionMonitor {
+	return &transmissionMonitor{
+		stallTimeout: stallTimeout,
+		left:         make(map[int64]int64),
+		progressed:   make(map[int64]time.Time),
+	}
+}
+
+func (m *transmissionMonitor) update(torrents []*trpc.Torrent, now time.Time) *TransmissionProgress {
+	p := &TransmissionProgress{Torrents: len(torrents)}
+	for _, t := range torrents {
+		left := *t.LeftUntilDone
+		p.LeftUntilDone += left
+		p.SizeWhenDone += int64(t.SizeWhenDone.Byte())
+		p.RateDownload += *t.RateDownload
+		errored := t.Error != nil && *t.Error != 0
+		if errored {
+			p.Errors = append(p.Errors, fmt.Sprintf("%s: %s", *t.Name, *t.ErrorString))
+		}
+		if left == 0 {
+			p.Done++
+			continue
+		}
+		stuck := errored
+		if t.Status != nil && *t.Status == trpc.TorrentStatusStopped {
+			p.Paused = append(p.Paused, *t.Name)
+			stuck = true
+		}
+		if last, ok := m.left[*t.ID]; !ok || left < last || isTorrentWaiting(t) {
+			m.left[*t.ID] = left
+			m.progressed[*t.ID] = now
+		} else if now.Sub(m.progressed[*t.ID]) >= m.stallTimeout {
+			p.Stalled = append(p.Stalled, *t.Name)
+			stuck = true
+		}
+		if stuck {
+			p.Stuck++
+		}
+	}
+	return p
+}
+
+// isTorrentWaiting reports whether a torrent is queued or being
+// checked, so is not expected to progress.
+func isTorrentWaiting(t *trpc.Torrent) bool {
+	if t.Status == nil {
+		return false
+	}
+	switch *t.Status {
+	case trpc.TorrentStatusCheckWait, trpc.TorrentStatusCheck, trpc.TorrentStatusDownloadWait:
+		return true
+	}
+	return false
 }
 
 func checkVersion(c *trpc.Client) error {
@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hekmon/cunits/v2"
+	trpc "github.com/hekmon/transmissionrpc"
+
+	"github.com/andrewarchi/urlhero/ia"
+)
+
+func TestWantedFiles(t *testing.T) {
+	torrent := &ia.Torrent{Files: []ia.TorrentFile{
+		{Name: "bitly_6.2016-01-21-20-17-02.zip"},
+		{Name: "isgd.2016-01-21-20-17-02.zip"},
+		{Name: "urlteam_2016-01-21-20-17-02_meta.xml"},
+		{Name: "bitly_7.2016-01-21-20-17-02.zip"},
+	}}
+	wanted, unwanted := (&TransmissionOptions{Projects: []string{"bitly_*"}, SkipProjects: []string{"bitly_7"}}).wantedFiles(torrent)
+	if !reflect.DeepEqual(wanted, []int64{0, 2}) || !reflect.DeepEqual(unwanted, []int64{1, 3}) {
+		t.Errorf("got wanted %v, unwanted %v", wanted, unwanted)
+	}
+	wanted, _ = (*TransmissionOptions)(nil).wantedFiles(torrent)
+	if len(wanted) != 4 {
+		t.Errorf("nil options: got wanted %v, want all", wanted)
+	}
+	wanted, _ = (&TransmissionOptions{Projects: []string{"tinyurl"}}).wantedFiles(torrent)
+	if wanted != nil {
+		t.Errorf("no matching zips: got wanted %v, want none", wanted)
+	}
+}
+
+func TestTransmissionMonitor(t *testing.T) {
+	newTorrent := func(id, left int64) *trpc.Torrent {
+		name, size, rate, errno := "t", cunits.Bits(800), int64(10), int64(0)
+		return &trpc.Torrent{ID: &id, Name: &name, LeftUntilDone: &left, SizeWhenDone: &size, RateDownload: &rate, Error: &errno}
+	}
+	m := newTransmissionMonitor(time.Minute)
+	start := time.Now()
+	p := m.update([]*trpc.Torrent{newTorrent(1, 50), newTorrent(2, 0)}, start)
+	if p.Torrents != 2 || p.Done != 1 || p.LeftUntilDone != 50 || p.SizeWhenDone != 200 || p.RateDownload != 20 || len(p.Stalled) != 0 {
+		t.Errorf("first poll: got %+v", p)
+	}
+	p = m.update([]*trpc.Torrent{newTorrent(1, 50), newTorrent(2, 0)}, start.Add(2*time.Minute))
+	if len(p.Stalled) != 1 {
+		t.Errorf("no progress: got %+v, want 1 stalled", p)
+	}
+	p = m.update([]*trpc.Torrent{newTorrent(1, 40), newTorrent(2, 0)}, start.Add(3*time.Minute))
+	if len(p.Stalled) != 0 || p.Stuck != 0 {
+		t.Errorf("progress: got %+v, want none stalled", p)
+	}
+
+	// Queued torrents are not stalled, but paused and errored torrents
+	// are stuck.
+	queued, paused, errored := newTorrent(3, 10), newTorrent(4, 10), newTorrent(5, 10)
+	wait, stopped, errno := trpc.TorrentStatusDownloadWait, trpc.TorrentStatusStopped, int64(3)
+	errString := "tracker error"
+	queued.Status, paused.Status, errored.Error, errored.ErrorString = &wait, &stopped, &errno, &errString
+	torrents := []*trpc.Torrent{newTorrent(1, 40), queued, paused, errored}
+	m.update(torrents, start.Add(3*time.Minute))
+	p = m.update(torrents, start.Add(5*time.Minute))
+	if len(p.Stalled) != 3 || len(p.Paused) != 1 || len(p.Errors) != 1 || p.Stuck != 3 {
+		t.Errorf("stuck: got %+v, want torrents 1, 4, and 5 stalled and 3 stuck", p)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"encoding/csv"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andrewarchi/urlhero/beacon"
+)
+
+// DumpValidation counts the links in a link dump that are inconsistent
+// with the settings of the project. A line with a source of the wrong
+// length is counted in WrongLength when it looks like a link, with a URL
+// after the '|', rather than being appended to the previous target as a
+// line of a multi-line target.
+type DumpValidation struct {
+	BadAlphabet    int64 `json:"bad_alphabet"`    // sources with characters outside the alphabet
+	WrongLength    int64 `json:"wrong_length"`    // links with a source not of the shortcode length; skipped
+	AntiRegex      int64 `json:"anti_regex"`      // targets matching the location anti-regex
+	EmptyTarget    int64 `json:"empty_target"`    // empty targets
+	RelativeTarget int64 `json:"relative_target"` // targets that are not absolute URLs
+	// AntiRegexErr is why the location anti-regex, which is written for
+	// Python, could not be checked.
+	AntiRegexErr string `json:"anti_regex_error,omitempty"`
+}
+
+// Problems returns the number of problems found.
+func (v *DumpValidation) Problems() int64 {
+	return v.BadAlphabet + v.WrongLength + v.AntiRegex + v.EmptyTarget + v.RelativeTarget
+}
+
+// dumpValidator checks the links of a link dump.
+type dumpValidator struct {
+	v         DumpValidation
+	alphabet  string
+	antiRegex *regexp.Regexp
+}
+
+func newDumpValidator(meta *Meta) *dumpValidator {
+	dv := &dumpValidator{alphabet: meta.Alphabet}
+	if meta.LocationAntiRegex != "" {
+		re, err := regexp.Compile(meta.LocationAntiRegex)
+		if err != nil {
+			dv.v.AntiRegexErr = err.Error()
+		}
+		dv.antiRegex = re
+	}
+	return dv
+}
+
+func (dv *dumpValidator) check(l *beacon.Link) {
+	if dv.alphabet != "" {
+		for i := 0; i < len(l.Source); i++ {
+			if strings.IndexByte(dv.alphabet, l.Source[i]) == -1 {
+				dv.v.BadAlphabet++
+				break
+			}
+		}
+	}
+	if l.Target == "" {
+		dv.v.EmptyTarget++
+		return
+	}
+	if u, err := url.Parse(l.Target); err != nil || !u.IsAbs() || u.Host == "" {
+		dv.v.RelativeTarget++
+	}
+	if dv.antiRegex != nil && dv.antiRegex.MatchString(l.Target) {
+		dv.v.AntiRegex++
+	}
+}
+
+// ValidateReleases validates the links in the releases selected by the
+// options and returns the summaries of the dumps, with their
+// validation.
+func ValidateReleases(root string, options *ProcessOptions) ([]*DumpSummary, error) {
+	var o ProcessOptions
+	if options != nil {
+		o = *options
+	}
+	o.Validate = true
+	var dumps []*DumpSummary
+	done := o.DumpDone
+	o.DumpDone = func(d *DumpSummary) {
+		dumps = append(dumps, d)
+		if done != nil {
+			done(d)
+		}
+	}
+	err := ProcessReleases(root, &o, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		return nil
+	})
+	return dumps, err
+}
+
+// WriteValidationCSV writes the validation of dumps as CSV with a
+// header.
+func WriteValidationCSV(w io.Writer, dumps []*DumpSummary) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "release", "dump", "links",
+		"bad_alphabet", "wrong_length", "anti_regex", "empty_target", "relative_target", "anti_regex_error"})
+	for _, d := range dumps {
+		v := d.Validation
+		if v == nil {
+			v = &DumpValidation{}
+		}
+		row := []string{d.Meta.Name, filepath.Base(d.ReleaseFilename), d.DumpFilename, strconv.FormatInt(d.Links, 10)}
+		row = append(row, formatInts(v.BadAlphabet, v.WrongLength, v.AntiRegex, v.EmptyTarget, v.RelativeTarget)...)
+		cw.Write(append(row, v.AntiRegexErr))
+	}
+	cw.Flush()
+	return cw.Error()
+}
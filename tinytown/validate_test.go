@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateReleases(t *testing.T) {
+	projects := []testProject{
+		{"urlteam_2016-01-21-20-17-02", Meta{
+			Name:              "bitly_6",
+			Alphabet:          "0123456789abcdef",
+			LocationAntiRegex: `^https?://bit\.ly/`,
+		}, map[int][]string{
+			6: {
+				"0000b|http://example.com/b",
+				"00000a|http://example.com/a",
+				"0000000f|http://example.com/f",
+				"00000z|http://example.com/z",
+				"00000c|",
+				"00000d|/relative",
+				"00000e|http://bit.ly/error",
+			},
+		}},
+		{"urlteam_2016-01-21-20-17-02", Meta{Name: "isgd", Alphabet: "0123456789abcdef"}, map[int][]string{
+			6: {"00000c|http://example.com/c"},
+		}},
+	}
+	root := writeTestReleases(t, projects)
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			dumps, err := ValidateReleases(root, &ProcessOptions{Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dumps) != 2 {
+				t.Fatalf("got %d dumps", len(dumps))
+			}
+			for _, d := range dumps {
+				if d.Validation == nil {
+					t.Fatalf("%s: no validation", d.Meta.Name)
+				}
+				got := *d.Validation
+				var want DumpValidation
+				if d.Meta.Name == "bitly_6" {
+					want = DumpValidation{BadAlphabet: 1, WrongLength: 2, AntiRegex: 1, EmptyTarget: 1, RelativeTarget: 1}
+					if d.Links != 5 {
+						t.Errorf("bitly_6: got %d links, want 5", d.Links)
+					}
+				}
+				if got != want {
+					t.Errorf("%s: got %+v, want %+v", d.Meta.Name, got, want)
+				}
+			}
+
+			var b bytes.Buffer
+			if err := WriteValidationCSV(&b, dumps); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(b.String(), "bitly_6,bitly_6.2016-01-21-20-17-02.zip,xxxxxx.txt.xz,5,1,2,1,1,1,\n") {
+				t.Errorf("unexpected CSV:\n%s", b.String())
+			}
+		})
+	}
+}
@@ -7,16 +7,19 @@
 package tinytown
 
 import (
-	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/andrewarchi/archive"
 	"github.com/andrewarchi/browser/jsonutil"
 	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
 )
 
 // Meta contains link dump metadata from a *.meta.json.xz file.
@@ -46,61 +49,167 @@ type Meta struct {
 // visited.
 type ProcessFunc func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error
 
+// ProcessOptions restricts the releases, projects, and link dumps that
+// are processed and controls concurrency. A nil *ProcessOptions
+// processes everything sequentially.
+type ProcessOptions struct {
+	Start, End    time.Time // release time range [Start, End); zero times are unbounded
+	Projects      []string  // project name patterns to process, e.g., "bitly_*"; all when empty
+	SkipProjects  []string  // project name patterns to skip
+	ShortcodeLens []int     // shortcode lengths to process; all when empty
+	// DumpFilter reports whether to process a link dump in a project zip.
+	DumpFilter func(releaseFilename, dumpFilename string) bool
+
+	// Workers is the number of link dumps to decode concurrently. When
+	// greater than 1, project zips and link dumps are decoded by a pool
+	// of workers, but fn is still only called from a single goroutine.
+	Workers int
+	// Unordered delivers links from concurrent workers as soon as they
+	// are decoded, rather than in the order of a sequential traversal.
+	Unordered bool
+	// BufferedLinks bounds the links decoded ahead of delivery for
+	// ordered delivery, so that later dumps are decoded in parallel
+	// while the earliest is delivered; defaults to 1<<20.
+	BufferedLinks int
+
+	// DumpDone is called after all links in a link dump have been
+	// processed without error, from the same goroutine as fn.
+	DumpDone func(d *DumpSummary)
+	// Observer is notified of the start and finish of each link dump,
+	// from the same goroutine as fn. With concurrent workers, both are
+	// reported once the links of the dump have been delivered.
+	Observer progress.Observer
+
+	// Validate checks the links of each dump against the project
+	// settings, and reports the problems found in DumpSummary.Validation.
+	// Links with a source of the wrong length are skipped, rather than
+	// stopping the dump.
+	Validate bool
+
+	// Checkpoint, if non-nil, skips the link dumps that it records as
+	// processed and records the dumps processed by this traversal. A
+	// resumed dump is summarized from its checkpointed offset.
+	Checkpoint *Checkpoint
+}
+
+// DumpSummary describes a link dump that has been processed.
+type DumpSummary struct {
+	Meta            *Meta
+	ReleaseFilename string
+	DumpFilename    string
+	Links           int64
+	CompressedSize  int64 // bytes of xz-compressed dump
+	Size            int64 // bytes of decompressed dump
+	// Validation counts the links inconsistent with Meta, when
+	// ProcessOptions.Validate is set.
+	Validation *DumpValidation
+}
+
 // ProcessReleases processes every release in a directory by calling fn
-// on every link.
-func ProcessReleases(root string, fn ProcessFunc) error {
-	// TODO allow user to skip releases or projects.
-	rootContents, err := os.ReadDir(root)
+// on every link. The directory is laid out as for ProcessReleasesFS.
+func ProcessReleases(root string, options *ProcessOptions, fn ProcessFunc) error {
+	it, err := IterReleases(context.Background(), root, options)
 	if err != nil {
 		return err
 	}
-	for _, release := range rootContents {
-		if !release.IsDir() {
-			continue
-		}
-		dir := filepath.Join(root, release.Name())
-		dirContents, err := os.ReadDir(dir)
-		if err != nil {
-			return err
-		}
-		for _, file := range dirContents {
-			filename := filepath.Join(dir, file.Name())
-			if !strings.HasSuffix(filename, ".zip") {
-				continue
-			}
-			if err := ProcessProject(filename, fn); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return processIter(it, fn)
 }
 
 // ProcessProject processes every link dump in a project release by
-// calling fn on every link.
-func ProcessProject(filename string, fn ProcessFunc) error {
-	zr, err := zip.OpenReader(filename)
-	if err != nil {
-		return err
+// calling fn on every link. The filename may be an HTTP URL, such as
+// of a zip in an archive.org item, in which case only the zip directory
+// and the selected link dumps are downloaded.
+func ProcessProject(filename string, options *ProcessOptions, fn ProcessFunc) error {
+	return processIter(IterProject(context.Background(), filename, options), fn)
+}
+
+// dumpShortcodeLen returns the shortcode length of a link dump, which is
+// the length of its name without extension.
+func dumpShortcodeLen(dumpFilename string) int {
+	return len(filepath.Base(dumpFilename)) - len(".txt.xz")
+}
+
+// matchTime reports whether the time in a release identifier or project
+// zip name is in range. Names without a time are not excluded.
+func (o *ProcessOptions) matchTime(name string) bool {
+	if o == nil || (o.Start.IsZero() && o.End.IsZero()) {
+		return true
 	}
-	defer zr.Close()
-	metaFile, dumps, err := classifyFiles(zr.File, filename)
-	if err != nil {
-		return err
+	t, ok := parseReleaseTime(name)
+	if !ok {
+		return true
 	}
-	meta, err := readMeta(metaFile)
-	if err != nil {
-		return err
+	return (o.Start.IsZero() || !t.Before(o.Start)) && (o.End.IsZero() || t.Before(o.End))
+}
+
+func (o *ProcessOptions) matchProject(project string) bool {
+	if o == nil {
+		return true
 	}
-	for _, f := range dumps {
-		if err := processLinkDump(f, filename, meta, fn); err != nil {
-			return err
+	if len(o.Projects) != 0 && !matchAny(o.Projects, project) {
+		return false
+	}
+	return !matchAny(o.SkipProjects, project)
+}
+
+func (o *ProcessOptions) dumpDone(d *DumpSummary) {
+	if o != nil && o.DumpDone != nil {
+		o.DumpDone(d)
+	}
+}
+
+func (o *ProcessOptions) observer() progress.Observer {
+	if o == nil {
+		return progress.Nop{}
+	}
+	return progress.Or(o.Observer)
+}
+
+func (o *ProcessOptions) matchDump(releaseFilename, dumpFilename string) bool {
+	if o == nil {
+		return true
+	}
+	if len(o.ShortcodeLens) != 0 {
+		n := dumpShortcodeLen(dumpFilename)
+		found := false
+		for _, l := range o.ShortcodeLens {
+			if l == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
 	}
-	return nil
+	return (o.DumpFilter == nil || o.DumpFilter(releaseFilename, dumpFilename)) &&
+		!o.Checkpoint.Done(releaseFilename, dumpFilename)
 }
 
-func classifyFiles(files []*zip.File, filename string) (meta *zip.File, dumps []*zip.File, err error) {
+func (o *ProcessOptions) checkpoint() *Checkpoint {
+	if o == nil {
+		return nil
+	}
+	return o.Checkpoint
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func trimAfterByte(s string, c byte) string {
+	if i := strings.IndexByte(s, c); i != -1 {
+		return s[:i]
+	}
+	return s
+}
+
+func classifyFiles(files []*projectFile, filename string) (meta *projectFile, dumps []*projectFile, err error) {
 	// Before 2015-07-29, project zip archives were sorted with meta
 	// first, followed by dumps in increasing shortcode length. Later
 	// archives do not sort files.
@@ -119,7 +228,7 @@ meta:
 		for i, f := range files {
 			if strings.HasSuffix(f.Name, ".meta.json.xz") {
 				meta = f
-				dumps = make([]*zip.File, 0, len(files)-1)
+				dumps = make([]*projectFile, 0, len(files)-1)
 				dumps = append(append(dumps, files[:i]...), files[i+1:]...)
 				break meta
 			}
@@ -135,7 +244,7 @@ meta:
 	return
 }
 
-func readMeta(f *zip.File) (*Meta, error) {
+func readMeta(f *projectFile) (*Meta, error) {
 	fr, err := f.Open()
 	if err != nil {
 		return nil, err
@@ -146,41 +255,139 @@ func readMeta(f *zip.File) (*Meta, error) {
 		return nil, err
 	}
 	defer xr.Close()
+	return decodeMeta(xr)
+}
+
+func decodeMeta(r io.Reader) (*Meta, error) {
 	var m Meta
-	if err := jsonutil.Decode(xr, &m); err != nil {
+	if err := jsonutil.Decode(r, &m); err != nil {
 		return nil, err
 	}
 	return &m, nil
 }
 
-func processLinkDump(f *zip.File, filename string, meta *Meta, fn ProcessFunc) error {
+// readLinkDump calls fn on every link in a link dump, starting at the
+// given byte offset in the decompressed dump, with the offset of each
+// link, and summarizes the dump. The links are optionally validated.
+func readLinkDump(f *projectFile, filename string, meta *Meta, offset int64, validate bool, fn func(l *beacon.Link, offset int64) error) (*DumpSummary, error) {
+	d := &DumpSummary{
+		Meta:            meta,
+		ReleaseFilename: filename,
+		DumpFilename:    f.Name,
+		CompressedSize:  f.Size,
+	}
+	dr, err := openLinkDump(f, offset)
+	if err != nil {
+		return d, err
+	}
+	defer dr.Close()
+	if validate {
+		dr.validate(meta)
+	}
+	for {
+		link, err := dr.Read()
+		if err != nil {
+			if err == io.EOF {
+				d.Size = dr.cr.n
+				d.Validation = dr.validation()
+				return d, nil
+			}
+			return d, err
+		}
+		d.Links++
+		if err := fn(link, offset+dr.Offset()); err != nil {
+			return d, err
+		}
+	}
+}
+
+// dumpReader reads links from a link dump in a project.
+type dumpReader struct {
+	*beacon.Reader
+	r, xr io.Closer
+	cr    *countReader   // decompressed bytes
+	v     *dumpValidator // validates links, when non-nil
+}
+
+// openLinkDump opens a link dump for reading, starting at the given
+// byte offset in the decompressed dump.
+func openLinkDump(f *projectFile, offset int64) (*dumpReader, error) {
 	r, err := f.Open()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer r.Close()
 	xr, err := archive.NewXZReader(r)
 	if err != nil {
-		return err
+		r.Close()
+		return nil, err
 	}
-	defer xr.Close()
+	if offset != 0 {
+		if _, err := io.CopyN(io.Discard, xr, offset); err != nil {
+			xr.Close()
+			r.Close()
+			return nil, err
+		}
+	}
+	cr := &countReader{r: xr, n: offset}
+	br := beacon.NewURLTeamReader(cr, dumpShortcodeLen(f.Name))
+	return &dumpReader{Reader: br, r: r, xr: xr, cr: cr}, nil
+}
 
-	shortcodeLen := len(filepath.Base(f.Name)) - len(".txt.xz")
-	br := beacon.NewURLTeamReader(xr, shortcodeLen)
-	fmt.Fprintf(os.Stderr, "%s:%s ", filepath.Base(filename), f.Name)
-	n := 0
+// validate checks the links read against the project settings. Lines
+// that look like links with a source of the wrong length are read as
+// such, rather than as part of a multi-line target, so they are counted.
+func (dr *dumpReader) validate(meta *Meta) {
+	dr.v = newDumpValidator(meta)
+	dr.SetStrictSources(true)
+}
+
+// Read reads the next link. When validating, links with a source of
+// the wrong length are counted and skipped, rather than stopping the
+// dump.
+func (dr *dumpReader) Read() (*beacon.Link, error) {
+	if dr.v == nil {
+		return dr.Reader.Read()
+	}
 	for {
-		link, err := br.Read()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "[%d links]\n", n)
-			if err == io.EOF {
-				return nil
-			}
-			return err
+		link, err := dr.Reader.Read()
+		var lenErr *beacon.SourceLenError
+		if errors.As(err, &lenErr) {
+			dr.v.v.WrongLength++
+			continue
 		}
-		n++
-		if err := fn(link, meta, shortcodeLen, filename, f.Name); err != nil {
-			return err
+		if err == nil {
+			dr.v.check(link)
 		}
+		return link, err
+	}
+}
+
+// validation returns the validation of the links read, or nil when not
+// validating.
+func (dr *dumpReader) validation() *DumpValidation {
+	if dr.v == nil {
+		return nil
 	}
+	v := dr.v.v
+	return &v
+}
+
+func (dr *dumpReader) Close() error {
+	err := dr.xr.Close()
+	if err1 := dr.r.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
 }
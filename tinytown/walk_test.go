@@ -0,0 +1,276 @@
+// Copyright (c) 2021 Andrew Archibald
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tinytown
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/andrewarchi/urlhero/beacon"
+	"github.com/andrewarchi/urlhero/progress"
+	"github.com/ulikunitz/xz"
+)
+
+// testProject is a project zip fixture.
+type testProject struct {
+	release string           // release identifier
+	meta    Meta             // project name is meta.Name
+	dumps   map[int][]string // shortcode length -> "shortcode|target" lines
+}
+
+var testReleases = []testProject{
+	{"urlteam_2015-12-31-20-17-02", Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{
+		6: {"00000a|http://example.com/2015"},
+	}},
+	{"urlteam_2016-01-21-20-17-02", Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{
+		5: {"0000a|http://example.com/5"},
+		6: {"00000a|http://example.com/a", "00000b|http://example.com/b"},
+	}},
+	{"urlteam_2016-01-21-20-17-02", Meta{Name: "isgd", Alphabet: "0123456789abcdef"}, map[int][]string{
+		6: {"00000c|http://example.com/c"},
+	}},
+}
+
+// writeTestReleases writes project zips into release directories under
+// root and returns root.
+func writeTestReleases(t *testing.T, projects []testProject) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, p := range projects {
+		dir := filepath.Join(root, p.release)
+		if err := os.MkdirAll(dir, 0o777); err != nil {
+			t.Fatal(err)
+		}
+		ts := strings.TrimPrefix(p.release, "urlteam_")
+		name := filepath.Join(dir, p.meta.Name+"."+ts+".zip")
+		if err := os.WriteFile(name, makeTestZip(t, p), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func makeTestZip(t *testing.T, p testProject) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	meta, err := json.Marshal(&p.meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestXZ(t, zw, p.meta.Name+".meta.json.xz", meta)
+	lens := make([]int, 0, len(p.dumps))
+	for n := range p.dumps {
+		lens = append(lens, n)
+	}
+	sort.Ints(lens)
+	for _, n := range lens {
+		var dump bytes.Buffer
+		for _, line := range p.dumps[n] {
+			dump.WriteString(line + "\n")
+		}
+		writeTestXZ(t, zw, strings.Repeat("x", n)+".txt.xz", dump.Bytes())
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func writeTestXZ(t *testing.T, zw *zip.Writer, name string, data []byte) {
+	t.Helper()
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw, err := xz.NewWriter(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func collectSources(t *testing.T, root string, options *ProcessOptions) []string {
+	t.Helper()
+	var sources []string
+	err := ProcessReleases(root, options, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		sources = append(sources, m.Name+":"+l.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sources
+}
+
+func TestProcessReleasesOptions(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	tests := []struct {
+		options *ProcessOptions
+		sources []string
+	}{
+		{nil, []string{"bitly_6:00000a", "bitly_6:0000a", "bitly_6:00000a", "bitly_6:00000b", "isgd:00000c"}},
+		{&ProcessOptions{Start: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+			[]string{"bitly_6:0000a", "bitly_6:00000a", "bitly_6:00000b", "isgd:00000c"}},
+		{&ProcessOptions{End: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+			[]string{"bitly_6:00000a"}},
+		{&ProcessOptions{Projects: []string{"bitly_*"}, ShortcodeLens: []int{6}},
+			[]string{"bitly_6:00000a", "bitly_6:00000a", "bitly_6:00000b"}},
+		{&ProcessOptions{SkipProjects: []string{"bitly_6"}},
+			[]string{"isgd:00000c"}},
+		{&ProcessOptions{DumpFilter: func(releaseFilename, dumpFilename string) bool {
+			return strings.HasPrefix(filepath.Base(releaseFilename), "isgd.")
+		}}, []string{"isgd:00000c"}},
+	}
+	for i, tt := range tests {
+		sources := collectSources(t, root, tt.options)
+		if !reflect.DeepEqual(sources, tt.sources) {
+			t.Errorf("#%d: got %q, want %q", i, sources, tt.sources)
+		}
+	}
+}
+
+func TestProcessConcurrent(t *testing.T) {
+	projects := append([]testProject(nil), testReleases...)
+	var lines []string
+	for i := 0; i < 3*linkBatchSize+10; i++ {
+		lines = append(lines, fmt.Sprintf("%06x|http://example.com/%d", i, i))
+	}
+	projects = append(projects, testProject{"urlteam_2016-02-01-20-17-02",
+		Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"}, map[int][]string{6: lines, 7: {"000000a|http://example.com/7"}}})
+	root := writeTestReleases(t, projects)
+
+	want := collectSources(t, root, nil)
+	got := collectSources(t, root, &ProcessOptions{Workers: 4})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ordered: got %d links, want %d links in sequential order", len(got), len(want))
+	}
+	got = collectSources(t, root, &ProcessOptions{Workers: 4, Unordered: true})
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unordered: got %d links, want %d links", len(got), len(want))
+	}
+
+	errStop := errors.New("stop")
+	for _, unordered := range []bool{false, true} {
+		n := 0
+		err := ProcessReleases(root, &ProcessOptions{Workers: 4, Unordered: unordered}, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+			n++
+			if n == 100 {
+				return errStop
+			}
+			return nil
+		})
+		if err != errStop || n != 100 {
+			t.Errorf("unordered=%t: got error %v after %d links, want %v after 100 links", unordered, err, n, errStop)
+		}
+	}
+}
+
+// closeCountFS counts the link dumps that have been closed, which
+// happens once they are fully decoded.
+type closeCountFS struct {
+	fs.FS
+	closed chan string
+}
+
+type closeCountFile struct {
+	fs.File
+	name   string
+	closed chan string
+}
+
+func (fsys closeCountFS) Open(name string) (fs.File, error) {
+	f, err := fsys.FS.Open(name)
+	if err != nil || !strings.HasSuffix(name, ".txt.xz") {
+		return f, err
+	}
+	return closeCountFile{f, name, fsys.closed}, nil
+}
+
+func (f closeCountFile) Close() error {
+	f.closed <- f.name
+	return f.File.Close()
+}
+
+func TestProcessConcurrentLookahead(t *testing.T) {
+	// An extracted project with several dumps of multiple batches each
+	name := "urlteam_2016-02-01-20-17-02/bitly_6.2016-02-01-20-17-02"
+	meta, err := json.Marshal(&Meta{Name: "bitly_6", Alphabet: "0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{name + "/bitly_6.meta.json.xz": &fstest.MapFile{Data: makeTestXZ(t, meta)}}
+	const dumps = 4
+	for n := 6; n < 6+dumps; n++ {
+		var b strings.Builder
+		for i := 0; i < 4*linkBatchSize; i++ {
+			fmt.Fprintf(&b, "%0*x|http://example.com/%d\n", n, i, i)
+		}
+		fsys[name+"/"+strings.Repeat("x", n)+".txt.xz"] = &fstest.MapFile{Data: makeTestXZ(t, []byte(b.String()))}
+	}
+	want := collectSourcesFS(t, fsys, nil)
+
+	// The consumer blocks on the first link until every dump has been
+	// decoded, which requires the later dumps to decode ahead in
+	// parallel rather than waiting for delivery.
+	closed := make(chan string, dumps)
+	var got []string
+	err = ProcessReleasesFS(closeCountFS{fsys, closed}, &ProcessOptions{Workers: dumps}, func(l *beacon.Link, m *Meta, shortcodeLen int, releaseFilename, dumpFilename string) error {
+		if len(got) == 0 {
+			timeout := time.After(10 * time.Second)
+			for i := 0; i < dumps; i++ {
+				select {
+				case <-closed:
+				case <-timeout:
+					return fmt.Errorf("only %d of %d dumps decoded ahead of delivery", i, dumps)
+				}
+			}
+		}
+		got = append(got, m.Name+":"+l.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d links, want %d links in sequential order", len(got), len(want))
+	}
+}
+
+func TestProcessObserver(t *testing.T) {
+	root := writeTestReleases(t, testReleases)
+	want := "bitly_6.2015-12-31-20-17-02.zip:xxxxxx.txt.xz [1 links]\n" +
+		"bitly_6.2016-01-21-20-17-02.zip:xxxxx.txt.xz [1 links]\n" +
+		"bitly_6.2016-01-21-20-17-02.zip:xxxxxx.txt.xz [2 links]\n" +
+		"isgd.2016-01-21-20-17-02.zip:xxxxxx.txt.xz [1 links]\n"
+	for _, workers := range []int{1, 4} {
+		var b bytes.Buffer
+		collectSources(t, root, &ProcessOptions{Workers: workers, Observer: progress.NewWriter(&b)})
+		if got := b.String(); got != want {
+			t.Errorf("workers=%d: got:\n%s\nwant:\n%s", workers, got, want)
+		}
+	}
+}